@@ -7,12 +7,34 @@ import (
 	"github.com/urfave/cli"
 	"gopkg.in/cheggaaa/pb.v1"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 )
 
+var signatureFlag = cli.StringFlag{
+	Name:  "signature",
+	Value: zipper.SignatureFast,
+	Usage: "Signature strategy to use (fast, full, metadata or content), supported strategies depend on source type",
+}
+
+var includeFlag = cli.StringSliceFlag{
+	Name:  "include",
+	Usage: "Only keep paths matching this pattern, in .gitignore format (can be set multiple times)",
+}
+
+var excludeFlag = cli.StringSliceFlag{
+	Name:  "exclude",
+	Usage: "Remove paths matching this pattern, in .gitignore format (can be set multiple times)",
+}
+
+var archiveIgnoreFlag = cli.BoolFlag{
+	Name:  "archive-ignore",
+	Usage: "Honour ignore files (.zipignore, ...) found inside an archive source as for a directory",
+}
+
 func main() {
 	app := cli.NewApp()
 	app.Version = "1.0.0"
@@ -25,7 +47,29 @@ func main() {
 		},
 		cli.BoolFlag{
 			Name:  "insecure, k",
-			Usage: "Ignore certificate validation",
+			Usage: "Ignore certificate validation and ssh host keys of git repositories",
+		},
+		cli.StringFlag{
+			Name:   "git-cache-dir",
+			Usage:  "Keep git repositories as bare mirrors in this directory between calls",
+			EnvVar: "ZIPPER_GIT_CACHE_DIR",
+		},
+		cli.StringSliceFlag{
+			Name:  "known-hosts",
+			Usage: "Known hosts file used to verify host keys of git repositories over ssh (can be repeated)",
+		},
+		cli.StringSliceFlag{
+			Name:  "host-key-fingerprint",
+			Usage: "Accept ssh host key with this fingerprint (e.g.: SHA256:...) for git repositories (can be repeated)",
+		},
+		cli.StringFlag{
+			Name:  "ssh-key",
+			Usage: "Private key file in PEM format used for git repositories over ssh instead of ssh agent (key content can be given with env var ZIPPER_GIT_SSH_KEY)",
+		},
+		cli.StringFlag{
+			Name:   "ssh-key-password",
+			Usage:  "Password of private key given with --ssh-key",
+			EnvVar: "ZIPPER_GIT_SSH_KEY_PASSWORD",
 		},
 	}
 
@@ -39,7 +83,31 @@ func main() {
 				cli.StringFlag{
 					Name:  "output, o",
 					Value: "content.zip",
-					Usage: "zip file in another path (you can set to - to write in stdout)",
+					Usage: "zip file in another path (you can set to - to write in stdout), default extension follows format",
+				},
+				cli.StringFlag{
+					Name:  "format, f",
+					Value: zipper.FormatZip,
+					Usage: "Archive format to create (zip, tar, tar.gz or tar.zst)",
+				},
+				cli.BoolFlag{
+					Name:  "stream",
+					Usage: "Stream zip while writing it instead of creating it first in a temp file",
+				},
+				cli.BoolFlag{
+					Name:  "reproducible",
+					Usage: "Create a byte-for-byte reproducible archive (sorted entries, normalized modes and modification times from SOURCE_DATE_EPOCH or git commit time)",
+				},
+				includeFlag,
+				excludeFlag,
+				archiveIgnoreFlag,
+				cli.BoolFlag{
+					Name:  "submodules",
+					Usage: "Clone submodules of a git repository",
+				},
+				cli.BoolFlag{
+					Name:  "lfs",
+					Usage: "Replace git lfs pointer files of a git repository by their content",
 				},
 			},
 			Action: zip,
@@ -49,6 +117,7 @@ func main() {
 			Aliases:   []string{"s"},
 			Usage:     "Get sha1 signature for the file from source",
 			ArgsUsage: "<source uri>",
+			Flags:     []cli.Flag{signatureFlag, includeFlag, excludeFlag, archiveIgnoreFlag},
 			Action:    sha1,
 		},
 		{
@@ -56,6 +125,7 @@ func main() {
 			Aliases:   []string{"s"},
 			Usage:     "Check if file from source is different from your stored sha1",
 			ArgsUsage: "<source uri> <stored sha1>",
+			Flags:     []cli.Flag{signatureFlag, includeFlag, excludeFlag, archiveIgnoreFlag},
 			Action:    diff,
 		},
 	}
@@ -85,12 +155,44 @@ func createSession(c *cli.Context) (*zipper.Session, error) {
 			},
 		},
 	})
+	zipper.SetGitCacheDir(c.GlobalString("git-cache-dir"))
+	privateKey, err := sshPrivateKey(c)
+	if err != nil {
+		return nil, err
+	}
+	zipper.SetGitSshOptions(&zipper.GitSshOptions{
+		KnownHostsFiles:     c.GlobalStringSlice("known-hosts"),
+		HostKeyFingerprints: c.GlobalStringSlice("host-key-fingerprint"),
+		Insecure:            c.GlobalBool("insecure"),
+		PrivateKey:          privateKey,
+		PrivateKeyPassword:  c.GlobalString("ssh-key-password"),
+	})
+	err = zipper.SetSignatureStrategy(c.String("signature"))
+	if err != nil {
+		return nil, err
+	}
 	s, err := zipper.CreateSession(path, handlerType)
 	if err != nil {
 		return nil, err
 	}
+	s.SetIncludes(c.StringSlice("include")...)
+	s.SetExcludes(c.StringSlice("exclude")...)
+	s.SetArchiveIgnore(c.Bool("archive-ignore"))
+	if c.Bool("submodules") {
+		s.SetGitSubmodules(true)
+	}
+	if c.Bool("lfs") {
+		s.SetGitLfs(true)
+	}
 	return s, nil
 }
+func sshPrivateKey(c *cli.Context) ([]byte, error) {
+	keyFile := c.GlobalString("ssh-key")
+	if keyFile == "" {
+		return []byte(os.Getenv("ZIPPER_GIT_SSH_KEY")), nil
+	}
+	return ioutil.ReadFile(keyFile)
+}
 func diff(c *cli.Context) error {
 	s, err := createSession(c)
 	if err != nil {
@@ -128,17 +230,33 @@ func zip(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	z, err := s.Zip()
+	s.SetStreaming(c.Bool("stream"))
+	s.SetReproducible(c.Bool("reproducible"))
+	format, err := zipper.FindOutputFormat(c.String("format"))
+	if err != nil {
+		return err
+	}
+	s.SetOutputFormat(format)
+	z, err := s.Archive()
 	if err != nil {
 		return err
 	}
 	defer z.Close()
 	output := c.String("output")
+	if !c.IsSet("output") {
+		output = "content" + format.Ext()
+	}
 	if output == "-" {
 		_, err = io.Copy(os.Stdout, z)
 		return err
 	}
-	bar := pb.New(int(z.Size())).SetUnits(pb.U_BYTES).Prefix(filepath.Base(output))
+	bar := pb.New64(z.Size()).SetUnits(pb.U_BYTES).Prefix(filepath.Base(output))
+	if z.Size() == zipper.UnknownSize {
+		bar.Total = 0
+		bar.ShowPercent = false
+		bar.ShowBar = false
+		bar.ShowTimeLeft = false
+	}
 	bar.Start()
 
 	f, err := os.Create(output)
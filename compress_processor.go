@@ -1,15 +1,21 @@
 package zipper
 
 import (
-	"archive/tar"
 	"archive/zip"
+	"bufio"
+	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
+	"fmt"
 	"io"
 	"io/ioutil"
-	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
 )
 
 var ZIP_FILE_EXT []string = []string{
@@ -31,9 +37,157 @@ var TARGZ_FILE_EXT []string = []string{
 var BZ2_FILE_EXT []string = []string{
 	".bz2",
 }
+var TARBZ2_FILE_EXT []string = []string{
+	".tbz2",
+	".tbz",
+}
+var XZ_FILE_EXT []string = []string{
+	".xz",
+}
+var TARXZ_FILE_EXT []string = []string{
+	".txz",
+}
+var ZSTD_FILE_EXT []string = []string{
+	".zst",
+	".zstd",
+}
+var TARZSTD_FILE_EXT []string = []string{
+	".tzst",
+}
+var LZ4_FILE_EXT []string = []string{
+	".lz4",
+}
+
+// Decompressor of a compression format which can wrap content handled by a format converter or a single file, used by CompressProcessor
+type Decompressor interface {
+	// Detect if content is compressed in this format
+	// header contains first bytes of the content (up to 512 bytes) and name the file name
+	Detect(header []byte, name string) bool
+	// Name of decompressed content (e.g.: app.jar for app.jar.gz, app.tar for app.tgz)
+	DecompressedName(name string) string
+	// Create a reader giving decompressed content
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Decompressor detected from extensions of file name or from first bytes of content
+type ExtDecompressor struct {
+	// Extensions of a compressed file, removed from decompressed name (e.g.: .gz)
+	Exts []string
+	// Extensions of a compressed tar, replaced by .tar in decompressed name (e.g.: .tgz)
+	TarExts []string
+	// Detect format from first bytes of content, it can be nil
+	IsHeader func(header []byte) bool
+	// Create a reader giving decompressed content
+	Decompress func(r io.Reader) (io.ReadCloser, error)
+}
+
+func (d ExtDecompressor) Detect(header []byte, name string) bool {
+	return HasExtFile(name, d.Exts...) || HasExtFile(name, d.TarExts...) || (d.IsHeader != nil && d.IsHeader(header))
+}
+
+// name of the decompressed file, tar extensions (e.g.: .tgz) are replaced by .tar
+func (d ExtDecompressor) DecompressedName(name string) string {
+	if HasExtFile(name, d.TarExts...) {
+		return strings.TrimSuffix(name, filepath.Ext(name)) + ".tar"
+	}
+	if HasExtFile(name, d.Exts...) {
+		return strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name
+}
+
+func (d ExtDecompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return d.Decompress(r)
+}
+
+// Retrieve built-in decompressors: gzip, bzip2, xz, zstd and lz4
+func DefaultDecompressors() []Decompressor {
+	return []Decompressor{
+		ExtDecompressor{
+			Exts:     GZIP_FILE_EXT,
+			TarExts:  TARGZ_FILE_EXT,
+			IsHeader: isGzHeader,
+			Decompress: func(r io.Reader) (io.ReadCloser, error) {
+				return gzip.NewReader(r)
+			},
+		},
+		ExtDecompressor{
+			Exts:     BZ2_FILE_EXT,
+			TarExts:  TARBZ2_FILE_EXT,
+			IsHeader: isBz2Header,
+			Decompress: func(r io.Reader) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bzip2.NewReader(r)), nil
+			},
+		},
+		ExtDecompressor{
+			Exts:     XZ_FILE_EXT,
+			TarExts:  TARXZ_FILE_EXT,
+			IsHeader: isXzHeader,
+			Decompress: func(r io.Reader) (io.ReadCloser, error) {
+				xzr, err := xz.NewReader(r)
+				if err != nil {
+					return nil, err
+				}
+				return ioutil.NopCloser(xzr), nil
+			},
+		},
+		ExtDecompressor{
+			Exts:     ZSTD_FILE_EXT,
+			TarExts:  TARZSTD_FILE_EXT,
+			IsHeader: isZstdHeader,
+			Decompress: func(r io.Reader) (io.ReadCloser, error) {
+				zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+				if err != nil {
+					return nil, err
+				}
+				return zr.IOReadCloser(), nil
+			},
+		},
+		ExtDecompressor{
+			Exts:     LZ4_FILE_EXT,
+			IsHeader: isLZ4Header,
+			Decompress: func(r io.Reader) (io.ReadCloser, error) {
+				return ioutil.NopCloser(lz4.NewReader(r)), nil
+			},
+		},
+	}
+}
+
+// reader which close an other closer, useful for decompressing readers
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// close every closers in order and return the first error
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var firstErr error
+	for _, closer := range c {
+		err := closer.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// source reader which knows modification time of the source, it's given to a single decompressed file
+type modTimeReadCloser struct {
+	io.ReadCloser
+	modTime time.Time
+}
 
 type readCloserFunc func(src *Source) (io.ReadCloser, int64, string, error)
 
+// Function called with the opened source when it's not a zip, a tar (compressed or not) or a single compressed file
+// reader starts from the beginning of the source
+type FallbackFunc func(reader io.ReadCloser, size int64, path string) (ZipReadCloser, error)
+
+// number of first bytes peeked to detect format
+const sniffLen = 512
+
 type CompressProcessor struct {
 	src            *Source
 	readCloserFunc readCloserFunc
@@ -46,258 +200,197 @@ func NewCompressProcessor(src *Source, readCloserFunc readCloserFunc) *CompressP
 	}
 }
 
+// Convert source to zip when it's in a format handled by a format converter (compressed or not), e.g.: a zip or a tar,
+// or a single compressed file
+// It returns a nil zip file when source is not in one of these formats
 func (p CompressProcessor) ToZip() (ZipReadCloser, error) {
-	reader, _, path, err := p.readCloserFunc(p.src)
-	if err != nil {
-		return nil, err
-	}
-	isZipFile, err := p.isZipFile(reader, path)
-	if err != nil {
-		return nil, err
-	}
-	reader, dataLen, path, err := p.readCloserFunc(p.src)
-	if err != nil {
-		return nil, err
-	}
-	if isZipFile {
-		return NewZipFile(reader, dataLen, func() error {
-			return nil
-		}), nil
-	}
+	return p.ToZipOr(nil)
+}
 
-	isTarFile, err := p.isTarFile(reader, path)
+// Convert source to zip when it's in a format handled by a format converter (compressed or not), e.g.: a zip or a tar,
+// or a single compressed file, otherwise fallback is called.
+// Content can be compressed in a format of a decompressor set in source context (see SetCtxDecompressors),
+// gzip, bzip2, xz, zstd or lz4 by default.
+// Source is opened only once, format is detected from extension or by peeking first bytes.
+// It returns a nil zip file when source is not in one of these formats and fallback is nil
+func (p CompressProcessor) ToZipOr(fallback FallbackFunc) (ZipReadCloser, error) {
+	reader, size, path, err := p.readCloserFunc(p.src)
 	if err != nil {
 		return nil, err
 	}
-	reader, dataLen, path, err = p.readCloserFunc(p.src)
-	if err != nil {
+	br := bufio.NewReaderSize(reader, sniffLen)
+	header, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		reader.Close()
 		return nil, err
 	}
-	if isTarFile {
-		return p.tarToZip(reader)
-	}
+	name := filepath.Base(filepath.ToSlash(path))
 
-	isTarGzFile, err := p.isTarGzFile(reader, path)
-	if err != nil {
-		return nil, err
-	}
-	reader, dataLen, path, err = p.readCloserFunc(p.src)
-	if err != nil {
-		return nil, err
-	}
-	if isTarGzFile {
-		return p.tarGzToZip(reader)
+	converters := CtxFormatConverters(p.src)
+	if converter := findFormatConverter(converters, header, name); converter != nil {
+		return p.convertToZip(readCloser{br, reader}, size, converter)
 	}
 
-	isTarBz2File, err := p.isTarBz2File(reader, path)
-	if err != nil {
-		return nil, err
-	}
-	reader, dataLen, path, err = p.readCloserFunc(p.src)
-	if err != nil {
-		return nil, err
-	}
-	if isTarBz2File {
-		return p.tarBzip2ToZip(reader)
+	var content io.Reader = br
+	for _, d := range CtxDecompressors(p.src) {
+		if !d.Detect(header, name) {
+			continue
+		}
+		if len(header) == 0 {
+			reader.Close()
+			return nil, fmt.Errorf("Compressed content of '%s' is empty", path)
+		}
+		var innerHeader []byte
+		innerHeader, content, err = p.sniffDecompressed(br, d.NewReader)
+		if err != nil {
+			break
+		}
+		innerName := d.DecompressedName(name)
+		decompressed, err := d.NewReader(content)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		r := readCloser{decompressed, closers{decompressed, reader}}
+		if converter := findFormatConverter(converters, innerHeader, innerName); converter != nil {
+			return p.convertToZip(r, UnknownSize, converter)
+		}
+		return p.fileToZip(r, innerName, p.modTime(reader))
 	}
-	return nil, nil
-}
 
-func (p CompressProcessor) tarGzToZip(r io.ReadCloser) (*ZipFile, error) {
-	gzf, err := gzip.NewReader(r)
-	if err != nil {
-		return nil, err
+	if fallback == nil {
+		return nil, reader.Close()
 	}
-	return p.tarToZip(gzf)
+	return fallback(readCloser{content, reader}, size, path)
 }
 
-func (p CompressProcessor) tarBzip2ToZip(r io.ReadCloser) (*ZipFile, error) {
-	bz2 := bzip2.NewReader(r)
-
-	return p.tarToZip(ioutil.NopCloser(bz2))
+func findFormatConverter(converters []FormatConverter, header []byte, name string) FormatConverter {
+	for _, converter := range converters {
+		if converter.Detect(header, name) {
+			return converter
+		}
+	}
+	return nil
 }
 
-func (p CompressProcessor) tarToZip(r io.ReadCloser) (*ZipFile, error) {
-	zipFile, err := ioutil.TempFile("", "processor-zipper")
+// Retrieve first bytes of decompressed content, it returns an error when content can't be decompressed
+// Bytes consumed from reader are kept in memory and replayed by the returned reader
+func (p CompressProcessor) sniffDecompressed(reader io.Reader, decompress func(r io.Reader) (io.ReadCloser, error)) ([]byte, io.Reader, error) {
+	record := &bytes.Buffer{}
+	replay := io.MultiReader(record, reader)
+	decompressed, err := decompress(io.TeeReader(reader, record))
 	if err != nil {
-		return nil, err
+		return []byte{}, replay, err
 	}
-	cleanFunc := func() error {
-		return os.Remove(zipFile.Name())
-	}
-	err = p.writeTarToZip(r, zipFile)
-	if err != nil {
-		zipFile.Close()
-		return nil, err
-	}
-	zipFile.Close()
-	file, err := os.Open(zipFile.Name())
+	defer decompressed.Close()
+	header, err := Chunk(decompressed, sniffLen)
 	if err != nil {
-		return nil, err
+		return []byte{}, replay, err
 	}
-	fs, _ := file.Stat()
-	return NewZipFile(file, fs.Size(), cleanFunc), nil
-}
-
-func (p CompressProcessor) writeTarToZip(r io.Reader, zipFile *os.File) error {
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
-	tarReader := tar.NewReader(r)
-	hasRootFolder := false
-	i := 0
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
+	return header, replay, nil
+}
+
+// size is UnknownSize when content has been decompressed
+func (p CompressProcessor) convertToZip(r io.ReadCloser, size int64, converter FormatConverter) (ZipReadCloser, error) {
+	if zipConverter, ok := converter.(ZipFormatConverter); ok {
+		return zipConverter.ToZip(p.src, r, size)
+	}
+	if entryConverter, ok := converter.(entryFormatConverter); ok {
+		return makeArchiveFile(p.src, "processor-zipper", func(w EntryWriter) error {
+			err := entryConverter.convertEntries(p.src, r, w)
+			if err != nil {
+				return err
+			}
+			return drain(r)
+		}, r.Close)
+	}
+	return makeZipFile(p.src, "processor-zipper", func(w io.Writer) error {
+		zipWriter := zip.NewWriter(w)
+		defer zipWriter.Close()
+		var err error
+		if sourceConverter, ok := converter.(SourceFormatConverter); ok {
+			err = sourceConverter.ConvertSource(p.src, r, zipWriter)
+		} else {
+			err = converter.Convert(r, zipWriter)
 		}
 		if err != nil {
 			return err
 		}
-		fileInfo := header.FileInfo()
-		if i == 0 && fileInfo.IsDir() {
-			hasRootFolder = true
-			continue
-		}
-		zipHeader, err := zip.FileInfoHeader(fileInfo)
+		err = drain(r)
 		if err != nil {
 			return err
 		}
-		if !hasRootFolder {
-			zipHeader.Name = header.Name
-		} else {
-			splitFile := strings.Split(header.Name, "/")
-			zipHeader.Name = strings.Join(splitFile[1:], "/")
-		}
-		if !fileInfo.IsDir() {
-			zipHeader.Method = zip.Deflate
-		}
-		w, err := zipWriter.CreateHeader(zipHeader)
+		return zipWriter.Close()
+	}, r.Close)
+}
+
+// read content left by a converter (e.g.: after end of a tar) to verify checksums of compressed content
+func drain(r io.Reader) error {
+	_, err := io.Copy(ioutil.Discard, r)
+	return err
+}
+
+// modification time of the source when reader knows it, source date otherwise (see CtxSourceDate)
+func (p CompressProcessor) modTime(reader io.ReadCloser) time.Time {
+	if r, ok := reader.(modTimeReadCloser); ok && !r.modTime.IsZero() {
+		return r.modTime
+	}
+	return CtxSourceDate(p.src)
+}
+
+// create a zip containing only one file
+func (p CompressProcessor) fileToZip(r io.ReadCloser, name string, modTime time.Time) (*ZipFile, error) {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(4)
+	fh := &zip.FileHeader{
+		Name:   name,
+		Method: zip.Deflate,
+	}
+	fh.SetModTime(modTime)
+	if IsExecutable(bytes.NewReader(header)) {
+		fh.SetMode(0755)
+	} else {
+		fh.SetMode(0644)
+	}
+	return makeArchiveFile(p.src, "processor-zipper", func(w EntryWriter) error {
+		fw, err := w.CreateHeader(fh)
 		if err != nil {
 			return err
 		}
-		i++
-		if fileInfo.IsDir() {
-			continue
-		}
-		_, err = io.Copy(w, tarReader)
-	}
-	return nil
+		_, err = io.Copy(fw, br)
+		return err
+	}, r.Close)
 }
 
-func (p CompressProcessor) isTarFile(reader io.ReadCloser, path string) (bool, error) {
-	defer reader.Close()
-	if HasExtFile(path, TAR_FILE_EXT...) {
-		return true, nil
-	}
-	buf, err := Chunk(reader, 8)
-	if err != nil {
-		return false, err
-	}
-	if len(buf) < 8 {
-		return false, nil
+func isGzHeader(header []byte) bool {
+	if len(header) < 2 {
+		return false
 	}
-	if string(buf[:5]) != "ustar" {
-		return false, nil
-	}
-	return (buf[5] == 0x00 || buf[5] == 20) && (buf[6] == 0x30 || buf[6] == 20) && (buf[7] == 0x30 || buf[7] == 00), nil
+	return header[0] == 0x1F && header[1] == 0x8B
 }
 
-func (p CompressProcessor) isGzFile(reader io.ReadCloser, path string) (bool, error) {
-	defer reader.Close()
-	if HasExtFile(path, GZIP_FILE_EXT...) {
-		return true, nil
-	}
-	buf, err := Chunk(reader, 2)
-	if err != nil {
-		return false, err
-	}
-	if len(buf) < 2 {
-		return false, nil
-	}
-	return buf[0] == 0x1F && buf[1] == 0x8B, nil
+func isXzHeader(header []byte) bool {
+	return bytes.HasPrefix(header, []byte{0xFD, '7', 'z', 'X', 'Z', 0x00})
 }
 
-func (p CompressProcessor) isBz2File(reader io.ReadCloser, path string) (bool, error) {
-	defer reader.Close()
-	if HasExtFile(path, BZ2_FILE_EXT...) {
-		return true, nil
-	}
-	buf, err := Chunk(reader, 2)
-	if err != nil {
-		return false, err
-	}
-	if len(buf) < 2 {
-		return false, nil
-	}
-	return buf[0] == 0x42 && buf[1] == 0x5A, nil
+func isZstdHeader(header []byte) bool {
+	return bytes.HasPrefix(header, []byte{0x28, 0xB5, 0x2F, 0xFD})
 }
 
-func (p CompressProcessor) isZipFile(reader io.ReadCloser, path string) (bool, error) {
-	defer reader.Close()
-	if HasExtFile(path, ZIP_FILE_EXT...) {
-		return true, nil
-	}
-	buf, err := Chunk(reader, 4)
-	if err != nil {
-		return false, err
-	}
-	if len(buf) < 4 {
-		return false, nil
-	}
-	return buf[0] == 0x50 && buf[1] == 0x4b && (buf[2] == 0x03 || buf[2] == 0x05 || buf[2] == 0x07) && (buf[3] == 0x04 || buf[3] == 0x06 || buf[3] == 0x08), nil
+func isLZ4Header(header []byte) bool {
+	return bytes.HasPrefix(header, []byte{0x04, 0x22, 0x4D, 0x18})
 }
 
-func (p CompressProcessor) isTarGzFile(reader io.ReadCloser, path string) (bool, error) {
-	defer reader.Close()
-	isTgz := HasExtFile(path, TARGZ_FILE_EXT...)
-	if isTgz {
-		return true, nil
-	}
-	isGz := HasExtFile(path, GZIP_FILE_EXT...)
-	if isGz {
-		if IsTarFile(filepath.Ext(strings.TrimSuffix(path, filepath.Ext(path)))) {
-			return true, nil
-		}
-	}
-	isGz, err := p.isGzFile(reader, path)
-	if err != nil {
-		return false, err
-	}
-	if !isGz {
-		return false, nil
-	}
-	newReader, _, path, err := p.readCloserFunc(p.src)
-	if err != nil {
-		return false, err
+func isBz2Header(header []byte) bool {
+	if len(header) < 3 {
+		return false
 	}
-	defer newReader.Close()
-	gzf, err := gzip.NewReader(newReader)
-	if err != nil {
-		return false, err
-	}
-	return p.isTarFile(gzf, path)
+	return header[0] == 0x42 && header[1] == 0x5A && header[2] == 0x68
 }
 
-func (p CompressProcessor) isTarBz2File(reader io.ReadCloser, path string) (bool, error) {
-	defer reader.Close()
-	isBz2 := HasExtFile(path, BZ2_FILE_EXT...)
-	if isBz2 {
-		if IsTarFile(filepath.Ext(strings.TrimSuffix(path, filepath.Ext(path)))) {
-			return true, nil
-		}
-	}
-	isBz2, err := p.isBz2File(reader, path)
-	if err != nil {
-		return false, err
-	}
-	if !isBz2 {
-		return false, nil
-	}
-	newReader, _, path, err := p.readCloserFunc(p.src)
-	if err != nil {
-		return false, err
+func isZipHeader(header []byte) bool {
+	if len(header) < 4 {
+		return false
 	}
-	defer newReader.Close()
-	bz2 := bzip2.NewReader(newReader)
-	return p.isTarFile(ioutil.NopCloser(bz2), path)
+	return header[0] == 0x50 && header[1] == 0x4b && (header[2] == 0x03 || header[2] == 0x05 || header[2] == 0x07) && (header[3] == 0x04 || header[3] == 0x06 || header[3] == 0x08)
 }
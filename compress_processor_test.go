@@ -0,0 +1,524 @@
+package zipper_test
+
+import (
+	. "github.com/ArthurHlt/zipper"
+
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Convert files with .custom extension to a zip containing a custom.txt file
+type fakeConverter struct {
+}
+
+func (c fakeConverter) Detect(header []byte, name string) bool {
+	return HasExtFile(name, ".custom")
+}
+
+func (c fakeConverter) Convert(r io.Reader, w *zip.Writer) error {
+	fw, err := w.Create("custom.txt")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+// Convert zip files to a zip containing a custom.txt file with zip content
+type zipAsCustomConverter struct {
+	fakeConverter
+}
+
+func (c zipAsCustomConverter) Detect(header []byte, name string) bool {
+	return HasExtFile(name, ".zip")
+}
+
+// Decompress files with .rev extension which contain reversed content
+type reverseDecompressor struct {
+}
+
+func (d reverseDecompressor) Detect(header []byte, name string) bool {
+	return HasExtFile(name, ".rev")
+}
+
+func (d reverseDecompressor) DecompressedName(name string) string {
+	return strings.TrimSuffix(name, ".rev")
+}
+
+func (d reverseDecompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+var _ = Describe("CompressProcessor", func() {
+	var appDir string
+	var readCloserFunc func(path string) func(src *Source) (io.ReadCloser, int64, string, error)
+
+	BeforeEach(func() {
+		workingDir, err := os.Getwd()
+		Expect(err).NotTo(HaveOccurred())
+		appDir = filepath.Join(workingDir, "fixtures", "applications")
+
+		readCloserFunc = func(path string) func(src *Source) (io.ReadCloser, int64, string, error) {
+			return func(src *Source) (io.ReadCloser, int64, string, error) {
+				f, err := os.Open(filepath.Join(appDir, filepath.Base(src.Path)))
+				if err != nil {
+					return nil, 0, "", err
+				}
+				stat, _ := f.Stat()
+				return f, stat.Size(), path, nil
+			}
+		}
+	})
+	readZip := func(zipFile ZipReadCloser) *zip.Reader {
+		defer zipFile.Close()
+		b, err := ioutil.ReadAll(zipFile)
+		Expect(err).NotTo(HaveOccurred())
+		reader, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		Expect(err).NotTo(HaveOccurred())
+		return reader
+	}
+
+	for _, fixture := range []string{"final.tar.gz", "final.tar.xz", "final.tar.zst", "final.tar.lz4"} {
+		fixture := fixture
+		It("should convert "+fixture+" to zip", func() {
+			processor := NewCompressProcessor(NewSource(fixture), readCloserFunc(fixture))
+			zipFile, err := processor.ToZip()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(zipFile).ToNot(BeNil())
+			defer zipFile.Close()
+
+			checkZipFile(zipFile)
+		})
+		It("should detect "+fixture+" from its content when it has no extension", func() {
+			processor := NewCompressProcessor(NewSource(fixture), readCloserFunc("noext"))
+			zipFile, err := processor.ToZip()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(zipFile).ToNot(BeNil())
+			defer zipFile.Close()
+
+			checkZipFile(zipFile)
+		})
+	}
+
+	for _, fixture := range []string{"text.gz", "text.xz"} {
+		fixture := fixture
+		It("should create a zip containing the decompressed file named without extension from "+fixture, func() {
+			processor := NewCompressProcessor(NewSource(fixture), readCloserFunc(fixture))
+			zipFile, err := processor.ToZip()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(zipFile).ToNot(BeNil())
+
+			reader := readZip(zipFile)
+			Expect(reader.File).To(HaveLen(1))
+			name, content := readFileInZip(0, reader)
+			Expect(name).To(Equal("text"))
+
+			expected, err := ioutil.ReadFile(filepath.Join(appDir, "text"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(content).To(Equal(string(expected)))
+			Expect(reader.File[0].Modified.Equal(ReproducibleEpoch)).To(BeTrue())
+		})
+	}
+
+	It("should give modification time of source to the decompressed file when it's known", func() {
+		tmpDir, err := ioutil.TempDir("", "compress-test")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(tmpDir)
+		content, err := ioutil.ReadFile(filepath.Join(appDir, "text.gz"))
+		Expect(err).NotTo(HaveOccurred())
+		path := filepath.Join(tmpDir, "text.gz")
+		Expect(ioutil.WriteFile(path, content, 0644)).To(Succeed())
+		modTime := time.Date(2015, time.March, 4, 10, 20, 30, 0, time.UTC)
+		Expect(os.Chtimes(path, modTime, modTime)).To(Succeed())
+
+		zipFile, err := NewSession(NewSource(path), &LocalHandler{}).Zip()
+		Expect(err).NotTo(HaveOccurred())
+
+		reader := readZip(zipFile)
+		Expect(reader.File).To(HaveLen(1))
+		Expect(reader.File[0].Modified.Equal(modTime)).To(BeTrue())
+	})
+
+	Context("when using a custom format converter", func() {
+		It("should convert content with the first format converter which detects it", func() {
+			src := NewSource("text.gz")
+			SetCtxFormatConverters(src, append([]FormatConverter{&fakeConverter{}}, DefaultFormatConverters()...))
+			processor := NewCompressProcessor(src, readCloserFunc("text.custom.gz"))
+			zipFile, err := processor.ToZip()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(zipFile).ToNot(BeNil())
+
+			reader := readZip(zipFile)
+			Expect(reader.File).To(HaveLen(1))
+			name, content := readFileInZip(0, reader)
+			Expect(name).To(Equal("custom.txt"))
+
+			expected, err := ioutil.ReadFile(filepath.Join(appDir, "text"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(content).To(Equal(string(expected)))
+		})
+	})
+
+	It("should fail with a clear error when compressed content is empty", func() {
+		processor := NewCompressProcessor(NewSource("empty.gz"), func(src *Source) (io.ReadCloser, int64, string, error) {
+			return ioutil.NopCloser(bytes.NewReader([]byte{})), 0, "empty.gz", nil
+		})
+		zipFile, err := processor.ToZip()
+		Expect(err).To(HaveOccurred())
+		Expect(zipFile).To(BeNil())
+		Expect(err.Error()).To(ContainSubstring("Compressed content of 'empty.gz' is empty"))
+	})
+
+	Context("when lz4 frame is corrupted", func() {
+		convert := func(content []byte) error {
+			processor := NewCompressProcessor(NewSource("corrupted.lz4"), func(src *Source) (io.ReadCloser, int64, string, error) {
+				return ioutil.NopCloser(bytes.NewReader(content)), int64(len(content)), "corrupted.lz4", nil
+			})
+			zipFile, err := processor.ToZip()
+			if err != nil {
+				return err
+			}
+			zipFile.Close()
+			return nil
+		}
+		It("should fail when content checksum doesn't match", func() {
+			content, err := ioutil.ReadFile(filepath.Join(appDir, "final.tar.lz4"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(convert(content)).To(Succeed())
+
+			content[len(content)-1] ^= 0xFF
+			err = convert(content)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("checksum"))
+		})
+		It("should fail when a block is larger than block max size of frame", func() {
+			// frame with independent blocks of 64KB max and its header checksum
+			content := []byte{0x04, 0x22, 0x4D, 0x18, 0x60, 0x40, 0x82}
+			block := func(size int) {
+				header := make([]byte, 4)
+				binary.LittleEndian.PutUint32(header, uint32(size)|0x80000000)
+				content = append(content, header...)
+				content = append(content, bytes.Repeat([]byte("a"), size)...)
+			}
+			block(1024)
+			block(128 << 10)
+			content = append(content, 0, 0, 0, 0)
+
+			err := convert(content)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid block size"))
+		})
+	})
+
+	Context("when using custom decompressors", func() {
+		It("should decompress content with the first decompressor which detects it", func() {
+			src := NewSource("text.rev")
+			SetCtxDecompressors(src, append([]Decompressor{reverseDecompressor{}}, DefaultDecompressors()...))
+			processor := NewCompressProcessor(src, func(src *Source) (io.ReadCloser, int64, string, error) {
+				return ioutil.NopCloser(strings.NewReader("olleh")), 5, "text.rev", nil
+			})
+			zipFile, err := processor.ToZip()
+			Expect(err).NotTo(HaveOccurred())
+
+			reader := readZip(zipFile)
+			Expect(reader.File).To(HaveLen(1))
+			Expect(reader.File[0].Name).To(Equal("text"))
+			f, err := reader.File[0].Open()
+			Expect(err).NotTo(HaveOccurred())
+			defer f.Close()
+			content, err := ioutil.ReadAll(f)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("hello"))
+		})
+		It("should not decompress content when no decompressors are set", func() {
+			src := NewSource("text.gz")
+			SetCtxDecompressors(src, []Decompressor{})
+			processor := NewCompressProcessor(src, readCloserFunc("text.gz"))
+			zipFile, err := processor.ToZip()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(zipFile).To(BeNil())
+		})
+	})
+
+	Context("when zip is compressed", func() {
+		var zipContent []byte
+		var gzipReadCloser func(src *Source) (io.ReadCloser, int64, string, error)
+		BeforeEach(func() {
+			var err error
+			zipContent, err = ioutil.ReadFile(filepath.Join(appDir, "final.zip"))
+			Expect(err).NotTo(HaveOccurred())
+			buf := &bytes.Buffer{}
+			gw := gzip.NewWriter(buf)
+			_, err = gw.Write(zipContent)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gw.Close()).To(Succeed())
+			gzipReadCloser = func(src *Source) (io.ReadCloser, int64, string, error) {
+				return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), int64(buf.Len()), "final.zip.gz", nil
+			}
+		})
+		It("should give zip with its size when not streaming", func() {
+			processor := NewCompressProcessor(NewSource("final.zip.gz"), gzipReadCloser)
+			zipFile, err := processor.ToZip()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(zipFile.Size()).To(Equal(int64(len(zipContent))))
+
+			reader := readZip(zipFile)
+			Expect(reader.File).ToNot(BeEmpty())
+		})
+		It("should give zip with an unknown size when streaming", func() {
+			src := NewSource("final.zip.gz")
+			SetCtxStreaming(src, true)
+			processor := NewCompressProcessor(src, gzipReadCloser)
+			zipFile, err := processor.ToZip()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(zipFile.Size()).To(Equal(UnknownSize))
+
+			reader := readZip(zipFile)
+			Expect(reader.File).ToNot(BeEmpty())
+		})
+	})
+
+	Context("when zip converter is left out of format converters", func() {
+		It("should handle zip as any other content", func() {
+			src := NewSource("final.zip")
+			SetCtxFormatConverters(src, []FormatConverter{TarConverter{}})
+			processor := NewCompressProcessor(src, readCloserFunc("final.zip"))
+			zipFile, err := processor.ToZip()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(zipFile).To(BeNil())
+		})
+		It("should use a custom format converter detecting zip instead", func() {
+			src := NewSource("final.zip")
+			SetCtxFormatConverters(src, []FormatConverter{zipAsCustomConverter{}, TarConverter{}})
+			processor := NewCompressProcessor(src, readCloserFunc("final.zip"))
+			zipFile, err := processor.ToZip()
+			Expect(err).NotTo(HaveOccurred())
+			reader := readZip(zipFile)
+			Expect(reader.File).To(HaveLen(1))
+			Expect(reader.File[0].Name).To(Equal("custom.txt"))
+		})
+	})
+
+	Context("when include and exclude patterns are set", func() {
+		It("should only convert included and not excluded tar entries", func() {
+			src := NewSource("final.tar")
+			SetCtxIncludes(src, []string{"subDir/"})
+			SetCtxExcludes(src, []string{"otherDir/"})
+			processor := NewCompressProcessor(src, readCloserFunc("final.tar"))
+			zipFile, err := processor.ToZip()
+			Expect(err).NotTo(HaveOccurred())
+
+			reader := readZip(zipFile)
+			names := make([]string, 0)
+			for _, file := range reader.File {
+				names = append(names, file.Name)
+			}
+			Expect(names).To(Equal([]string{"subDir/", "subDir/bar.txt"}))
+		})
+	})
+
+	Context("when archive contains ignore files", func() {
+		entries := []struct {
+			name    string
+			content string
+		}{
+			{".zipignore", "*.log\nbuild/\n"},
+			{"a.txt", "content"},
+			{"build/", ""},
+			{"build/out.txt", "content"},
+			{"debug.log", "content"},
+			{"sub/", ""},
+			{"sub/.zipignore", "secret.txt\n"},
+			{"sub/keep.txt", "content"},
+			{"sub/secret.txt", "content"},
+		}
+		allNames := make([]string, 0)
+		for _, entry := range entries {
+			allNames = append(allNames, entry.name)
+		}
+		zipContent := func() []byte {
+			buf := &bytes.Buffer{}
+			zw := zip.NewWriter(buf)
+			for _, entry := range entries {
+				fw, err := zw.Create(entry.name)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = fw.Write([]byte(entry.content))
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(zw.Close()).To(Succeed())
+			return buf.Bytes()
+		}
+		tarContent := func() []byte {
+			buf := &bytes.Buffer{}
+			tw := tar.NewWriter(buf)
+			for _, entry := range entries {
+				header := &tar.Header{Name: entry.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(entry.content))}
+				if strings.HasSuffix(entry.name, "/") {
+					header.Typeflag = tar.TypeDir
+					header.Mode = 0755
+				}
+				Expect(tw.WriteHeader(header)).To(Succeed())
+				_, err := tw.Write([]byte(entry.content))
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(tw.Close()).To(Succeed())
+			return buf.Bytes()
+		}
+		convert := func(name string, content []byte, archiveIgnore bool) []string {
+			src := NewSource(name)
+			SetCtxArchiveIgnore(src, archiveIgnore)
+			processor := NewCompressProcessor(src, func(src *Source) (io.ReadCloser, int64, string, error) {
+				return ioutil.NopCloser(bytes.NewReader(content)), int64(len(content)), name, nil
+			})
+			zipFile, err := processor.ToZip()
+			Expect(err).NotTo(HaveOccurred())
+
+			reader := readZip(zipFile)
+			result := make([]string, 0)
+			for _, file := range reader.File {
+				result = append(result, file.Name)
+			}
+			return result
+		}
+
+		It("should keep every zip entries by default", func() {
+			Expect(convert("ignore.zip", zipContent(), false)).To(Equal(allNames))
+		})
+		It("should rewrite zip without ignored entries when asked", func() {
+			Expect(convert("ignore.zip", zipContent(), true)).To(Equal([]string{"a.txt", "sub/", "sub/keep.txt"}))
+		})
+		It("should keep every tar entries by default", func() {
+			Expect(convert("ignore.tar", tarContent(), false)).To(Equal(allNames))
+		})
+		It("should convert tar without ignored entries when asked", func() {
+			Expect(convert("ignore.tar", tarContent(), true)).To(Equal([]string{"a.txt", "sub/", "sub/keep.txt"}))
+		})
+	})
+
+	Context("when tar contains links", func() {
+		var tarContent []byte
+		BeforeEach(func() {
+			buf := &bytes.Buffer{}
+			tw := tar.NewWriter(buf)
+			entries := []*tar.Header{
+				{Name: "a.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 7},
+				{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755},
+				{Name: "dir/file.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 7},
+				{Name: "link.txt", Typeflag: tar.TypeSymlink, Linkname: "dir/file.txt", Mode: 0777},
+				{Name: "dir/hardlink.txt", Typeflag: tar.TypeLink, Linkname: "dir/file.txt", Mode: 0644},
+			}
+			for _, entry := range entries {
+				Expect(tw.WriteHeader(entry)).To(Succeed())
+				if entry.Size > 0 {
+					_, err := tw.Write([]byte("content"))
+					Expect(err).NotTo(HaveOccurred())
+				}
+			}
+			Expect(tw.Close()).To(Succeed())
+			tarContent = buf.Bytes()
+		})
+		convert := func(mode SymlinkMode) (*zip.Reader, error) {
+			src := NewSource("links.tar")
+			SetCtxSymlinkMode(src, mode)
+			processor := NewCompressProcessor(src, func(src *Source) (io.ReadCloser, int64, string, error) {
+				return ioutil.NopCloser(bytes.NewReader(tarContent)), int64(len(tarContent)), "links.tar", nil
+			})
+			zipFile, err := processor.ToZip()
+			if err != nil {
+				return nil, err
+			}
+			defer zipFile.Close()
+			b, err := ioutil.ReadAll(zipFile)
+			if err != nil {
+				return nil, err
+			}
+			return zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		}
+		names := func(reader *zip.Reader) []string {
+			result := make([]string, 0)
+			for _, file := range reader.File {
+				result = append(result, file.Name)
+			}
+			return result
+		}
+
+		It("should skip them by default", func() {
+			reader, err := convert(SymlinkSkip)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names(reader)).To(Equal([]string{"a.txt", "dir/", "dir/file.txt"}))
+		})
+		It("should store them as symlinks with target as content when preserving them", func() {
+			reader, err := convert(SymlinkPreserve)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names(reader)).To(Equal([]string{"a.txt", "dir/", "dir/file.txt", "link.txt", "dir/hardlink.txt"}))
+
+			Expect(reader.File[3].Mode() & os.ModeSymlink).ToNot(BeZero())
+			_, content := readFileInZip(3, reader)
+			Expect(content).To(Equal("dir/file.txt"))
+
+			Expect(reader.File[4].Mode() & os.ModeSymlink).ToNot(BeZero())
+			_, content = readFileInZip(4, reader)
+			Expect(content).To(Equal("file.txt"))
+		})
+		It("should store content of their targets when following them", func() {
+			reader, err := convert(SymlinkFollow)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names(reader)).To(Equal([]string{"a.txt", "dir/", "dir/file.txt", "dir/hardlink.txt", "link.txt"}))
+
+			for _, i := range []int{0, 2, 3, 4} {
+				Expect(reader.File[i].Mode().IsRegular()).To(BeTrue())
+				_, content := readFileInZip(i, reader)
+				Expect(content).To(Equal("content"))
+			}
+		})
+		It("should refuse a symlink escaping the archive", func() {
+			buf := &bytes.Buffer{}
+			tw := tar.NewWriter(buf)
+			Expect(tw.WriteHeader(&tar.Header{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "../../etc/passwd"})).To(Succeed())
+			Expect(tw.WriteHeader(&tar.Header{Name: "escape/file", Typeflag: tar.TypeReg, Mode: 0644})).To(Succeed())
+			Expect(tw.Close()).To(Succeed())
+			tarContent = buf.Bytes()
+
+			_, err := convert(SymlinkPreserve)
+			Expect(err).To(HaveOccurred())
+
+			_, err = convert(SymlinkFollow)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("should give the entire content to fallback when not an archive", func() {
+		processor := NewCompressProcessor(NewSource("executable"), readCloserFunc("executable"))
+		var content []byte
+		_, err := processor.ToZipOr(func(reader io.ReadCloser, size int64, path string) (ZipReadCloser, error) {
+			defer reader.Close()
+			var err error
+			content, err = ioutil.ReadAll(reader)
+			return nil, err
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		expected, err := ioutil.ReadFile(filepath.Join(appDir, "executable"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(content).To(Equal(expected))
+	})
+})
@@ -0,0 +1,250 @@
+package zipper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Username used with a token given by environment when no username is given for its host
+const DefaultTokenUsername = "x-access-token"
+
+// Credentials used to authenticate on a remote with basic auth
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Provider of credentials for urls without credentials inside them, used by git and http handlers
+type CredentialProvider interface {
+	// Retrieve credentials for url, nil credentials are returned when provider doesn't have any for it
+	Credentials(ctx context.Context, u *url.URL) (*Credentials, error)
+}
+
+// Credential provider trying each provider in order, credentials of the first one having some are used
+type CredentialProviders []CredentialProvider
+
+func (p CredentialProviders) Credentials(ctx context.Context, u *url.URL) (*Credentials, error) {
+	for _, provider := range p {
+		if provider == nil {
+			continue
+		}
+		creds, err := provider.Credentials(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		if creds != nil {
+			return creds, nil
+		}
+	}
+	return nil, nil
+}
+
+// Retrieve built-in credential providers, in order: environment variables, netrc file and git credential helpers
+func DefaultCredentialProviders() CredentialProviders {
+	return CredentialProviders{
+		EnvCredentials{},
+		NetrcCredentials{},
+		GitCredentialHelper{},
+	}
+}
+
+// Retrieve credentials for an url of a source, credential provider set in source context is used first
+// then default ones (see DefaultCredentialProviders)
+// Credentials must only be asked when remote requires authentication (e.g.: after a 401 response)
+func sourceCredentials(src *Source, u *url.URL) (*Credentials, error) {
+	return sourceCredentialProvider{src}.Credentials(src.Context(), u)
+}
+
+// Provider of credentials for urls of a source, default providers are not asked for plain http urls
+// unless it's allowed in source context (see SetCtxPlainHttpCredentials) to not send credentials in cleartext
+type sourceCredentialProvider struct {
+	src *Source
+}
+
+func (p sourceCredentialProvider) Credentials(ctx context.Context, u *url.URL) (*Credentials, error) {
+	providers := CredentialProviders{CtxCredentialProvider(p.src)}
+	if !strings.EqualFold(u.Scheme, "http") || CtxPlainHttpCredentials(p.src) {
+		providers = append(providers, DefaultCredentialProviders())
+	}
+	return providers.Credentials(ctx, u)
+}
+
+var envHostReplacer = regexp.MustCompile(`[^A-Z0-9]`)
+
+// Credential provider giving a token from ZIPPER_GIT_TOKEN_<HOST> environment variable, where HOST is host name
+// in upper case with every characters other than letters and digits replaced by underscore (e.g.: ZIPPER_GIT_TOKEN_GITHUB_COM)
+// Token is used as password with username from ZIPPER_GIT_USERNAME_<HOST>, or DefaultTokenUsername when not set
+type EnvCredentials struct {
+}
+
+func (e EnvCredentials) Credentials(ctx context.Context, u *url.URL) (*Credentials, error) {
+	suffix := envHostReplacer.ReplaceAllString(strings.ToUpper(u.Hostname()), "_")
+	token := os.Getenv("ZIPPER_GIT_TOKEN_" + suffix)
+	if token == "" {
+		return nil, nil
+	}
+	username := os.Getenv("ZIPPER_GIT_USERNAME_" + suffix)
+	if username == "" {
+		username = DefaultTokenUsername
+	}
+	return &Credentials{
+		Username: username,
+		Password: token,
+	}, nil
+}
+
+// Credential provider reading credentials of url host from a netrc file (see https://everything.curl.dev/usingcurl/netrc)
+// File is given by Path, or by NETRC environment variable, default to ~/.netrc (~/_netrc on windows)
+type NetrcCredentials struct {
+	Path string
+}
+
+func (n NetrcCredentials) Credentials(ctx context.Context, u *url.URL) (*Credentials, error) {
+	path := n.path()
+	if path == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseNetrc(string(content), u.Hostname()), nil
+}
+
+func (n NetrcCredentials) path() string {
+	if n.Path != "" {
+		return n.Path
+	}
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// Find credentials of host in netrc content, credentials of default entry are used when host has no machine entry
+func parseNetrc(content, host string) *Credentials {
+	var found, fallback *Credentials
+	var current *Credentials
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		// macro definition ends with an empty line
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			value := ""
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+			switch fields[i] {
+			case "machine":
+				current = nil
+				if found == nil && value == host {
+					found = &Credentials{}
+					current = found
+				}
+				i++
+			case "default":
+				current = nil
+				if fallback == nil {
+					fallback = &Credentials{}
+					current = fallback
+				}
+			case "login":
+				if current != nil {
+					current.Username = value
+				}
+				i++
+			case "password":
+				if current != nil {
+					current.Password = value
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				current = nil
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	if found != nil {
+		return found
+	}
+	return fallback
+}
+
+// Credential provider using git credential helpers protocol (see https://git-scm.com/docs/git-credential)
+// Command receives protocol, host and path of url on its standard input and must give username and password on its output
+// Default command is `git credential fill` which asks helpers set in git config (credential.helper),
+// a helper can also be called directly (e.g.: []string{"git-credential-store", "get"})
+// No credentials are given when command fails or is not found, user is never prompted
+type GitCredentialHelper struct {
+	Command []string
+}
+
+func (h GitCredentialHelper) Credentials(ctx context.Context, u *url.URL) (*Credentials, error) {
+	command := h.Command
+	if len(command) == 0 {
+		command = []string{"git", "credential", "fill"}
+	}
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return nil, nil
+	}
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf(
+		"protocol=%s\nhost=%s\npath=%s\n\n",
+		u.Scheme,
+		u.Host,
+		strings.TrimPrefix(u.Path, "/"),
+	))
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, nil
+	}
+	creds := &Credentials{}
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSuffix(line, "\r"), "=")
+		switch key {
+		case "username":
+			creds.Username = value
+		case "password":
+			creds.Password = value
+		}
+	}
+	if creds.Password == "" {
+		return nil, nil
+	}
+	return creds, nil
+}
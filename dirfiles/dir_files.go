@@ -4,13 +4,17 @@ come from
 package dirfiles
 
 import (
+	"context"
 	"crypto/sha1"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 
 	"strings"
 
@@ -33,9 +37,56 @@ type Files interface {
 	WalkAppFiles(dir string, onEachFile func(string, string) error) (err error)
 }
 
-type DirFiles struct{}
+// Files which can interrupt a walk when a context is done, see WalkAppFilesContext
+type ContextWalker interface {
+	WalkAppFilesContext(ctx context.Context, dir string, onEachFile func(string, string) error) (err error)
+}
+
+// Walk app files in dir with files, walk is interrupted with context error when context is done
+// Files which doesn't implement ContextWalker are walked with WalkAppFiles and context is checked before each file
+func WalkAppFilesContext(ctx context.Context, files Files, dir string, onEachFile func(string, string) error) error {
+	if walker, ok := files.(ContextWalker); ok {
+		return walker.WalkAppFilesContext(ctx, dir, onEachFile)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return files.WalkAppFiles(dir, func(fileName string, fullPath string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return onEachFile(fileName, fullPath)
+	})
+}
+
+// How symlinks are handled when walking a directory
+type SymlinkMode int
+
+const (
+	// Symlinks are skipped
+	SymlinkSkip SymlinkMode = iota
+	// Symlinks are given as they are (callers must use os.Lstat), a symlink with an absolute target
+	// or a target outside of the walked directory is refused
+	SymlinkPreserve
+	// Symlinks are followed and their targets are given instead (real path of target is given as full path),
+	// a symlink resolving outside of the walked directory or creating a cycle is refused
+	SymlinkFollow
+)
+
+type DirFiles struct {
+	Symlinks SymlinkMode
+	// Honour .gitignore files and .git/info/exclude in addition to .cfignore, .cloudignore and .zipignore files
+	GitIgnore bool
+	// Include and exclude patterns applied in addition to ignore rules
+	Filter *PathFilter
+}
 
 func (appfiles DirFiles) AppFilesInDir(dir string) ([]FileFields, error) {
+	return appfiles.AppFilesInDirContext(context.Background(), dir)
+}
+
+// Same as AppFilesInDir but stops and returns context error as soon as ctx is done
+func (appfiles DirFiles) AppFilesInDirContext(ctx context.Context, dir string) ([]FileFields, error) {
 	appFiles := []FileFields{}
 
 	fullDirPath, toplevelErr := filepath.Abs(dir)
@@ -43,7 +94,10 @@ func (appfiles DirFiles) AppFilesInDir(dir string) ([]FileFields, error) {
 		return appFiles, toplevelErr
 	}
 
-	toplevelErr = appfiles.WalkAppFiles(fullDirPath, func(fileName string, fullPath string) error {
+	toplevelErr = appfiles.WalkAppFilesContext(ctx, fullDirPath, func(fileName string, fullPath string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		fileInfo, err := os.Lstat(fullPath)
 		if err != nil {
 			return err
@@ -52,11 +106,18 @@ func (appfiles DirFiles) AppFilesInDir(dir string) ([]FileFields, error) {
 		appFile := FileFields{
 			Path: filepath.ToSlash(fileName),
 			Size: fileInfo.Size(),
+			Mode: fmt.Sprintf("%#o", fileInfo.Mode()),
 		}
 
 		if fileInfo.IsDir() {
 			appFile.Sha1 = "0"
 			appFile.Size = 0
+		} else if fileInfo.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(fullPath)
+			if err != nil {
+				return err
+			}
+			appFile.Sha1 = fmt.Sprintf("%x", sha1.Sum([]byte(target)))
 		} else {
 			sha, err := appfiles.shaFile(fullPath)
 			if err != nil {
@@ -73,6 +134,51 @@ func (appfiles DirFiles) AppFilesInDir(dir string) ([]FileFields, error) {
 	return appFiles, toplevelErr
 }
 
+// Create a merkle style sha1 from relative path, mode and sha1 of every non ignored files in dir
+// Any change of content, mode or name of a file change the final sha1
+func (appfiles DirFiles) ContentSha1(dir string) (string, error) {
+	return appfiles.ContentSha1Context(context.Background(), dir)
+}
+
+// Same as ContentSha1 but stops and returns context error as soon as ctx is done
+func (appfiles DirFiles) ContentSha1Context(ctx context.Context, dir string) (string, error) {
+	files, err := appfiles.AppFilesInDirContext(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	return ContentSha1(files), nil
+}
+
+// Create a merkle style sha1 from files given by AppFilesInDir
+// Each directory sha1 is made from mode, name and sha1 of its children sorted by name
+func ContentSha1(appFiles []FileFields) string {
+	children := make(map[string][]FileFields)
+	for _, appFile := range appFiles {
+		parent := path.Dir(appFile.Path)
+		if parent == "." {
+			parent = ""
+		}
+		children[parent] = append(children[parent], appFile)
+	}
+	return treeSha1(children, "")
+}
+
+func treeSha1(children map[string][]FileFields, dir string) string {
+	entries := children[dir]
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	hash := sha1.New()
+	for _, entry := range entries {
+		entrySha1 := entry.Sha1
+		if entry.Sha1 == "0" {
+			entrySha1 = treeSha1(children, entry.Path)
+		}
+		fmt.Fprintf(hash, "%s %s %s\n", entry.Mode, path.Base(entry.Path), entrySha1)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
 func (appfiles DirFiles) shaFile(fullPath string) (string, error) {
 	hash := sha1.New()
 	file, err := os.Open(fullPath)
@@ -172,9 +278,121 @@ func (appfiles DirFiles) CountFiles(directory string) int64 {
 }
 
 func (appfiles DirFiles) WalkAppFiles(dir string, onEachFile func(string, string) error) error {
-	cfIgnore := loadIgnoreFile(dir)
+	return appfiles.WalkAppFilesContext(context.Background(), dir, onEachFile)
+}
+
+// WalkAppFilesContext is like WalkAppFiles but walk is interrupted with context error when context is done
+// Ignore files found in subdirectories apply to paths inside their own directory, as .gitignore files do
+func (appfiles DirFiles) WalkAppFilesContext(ctx context.Context, dir string, onEachFile func(string, string) error) error {
+	return appfiles.walkFiles(ctx, dir, appfiles.loadIgnoreFile(dir), appfiles.ignorerFiles(), onEachFile)
+}
+
+// WalkFilesContext is like WalkAppFilesContext but with given ignore rules instead of the ones from ignore files in dir
+// nil ignore rules means that every files are walked
+func (appfiles DirFiles) WalkFilesContext(ctx context.Context, dir string, ignore IgnoreFiles, onEachFile func(string, string) error) error {
+	return appfiles.walkFiles(ctx, dir, ignore, nil, onEachFile)
+}
+
+// walk dir, ignorers are names of ignore files loaded from each walked subdirectory
+func (appfiles DirFiles) walkFiles(ctx context.Context, dir string, ignore IgnoreFiles, ignorers []string, onEachFile func(string, string) error) error {
+	if ignore == nil {
+		ignore = ignoreFile{}
+	}
+	w := &walker{
+		DirFiles:   appfiles,
+		ctx:        ctx,
+		root:       dir,
+		ignore:     ignore,
+		ignorers:   ignorers,
+		onEachFile: onEachFile,
+		pending:    make(map[string]pendingDir),
+	}
+	if appfiles.Symlinks == SymlinkFollow {
+		realRoot, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		w.realRoot, err = filepath.Abs(realRoot)
+		if err != nil {
+			return err
+		}
+		return w.walk(dir, "", []string{w.realRoot})
+	}
+	return w.walk(dir, "", nil)
+}
+
+type walker struct {
+	DirFiles
+	ctx        context.Context
+	root       string
+	realRoot   string
+	ignore     IgnoreFiles
+	ignorers   []string
+	onEachFile func(string, string) error
+	// directories not included by filter which are given only before an included path inside them
+	pending map[string]pendingDir
+}
+
+type pendingDir struct {
+	relativePath string
+	fullPath     string
+}
+
+func (w *walker) isIgnored(p string, isDir bool) bool {
+	if w.Filter.Excluded(p, isDir) {
+		return true
+	}
+	if dirIgnore, ok := w.ignore.(DirIgnoreFiles); ok {
+		return dirIgnore.PathShouldBeIgnored(p, isDir)
+	}
+	return w.ignore.FileShouldBeIgnored(p)
+}
+
+// add rules from ignore files found in dir, base is the slash separated path of dir relative to walked directory
+func (w *walker) loadNestedIgnoreFiles(dir, base string) {
+	rules, ok := w.ignore.(ignoreFile)
+	if !ok || len(w.ignorers) == 0 {
+		return
+	}
+	w.ignore = append(rules, loadIgnoreFilesInDir(dir, base, w.ignorers)...)
+}
+
+// give path to onEachFile when it's included by filter,
+// a directory which is not included is given only before the first included path inside it
+func (w *walker) visit(fileRelativePath, fullPath string, isDir bool) error {
+	unixPath := filepath.ToSlash(fileRelativePath)
+	if !w.Filter.Included(unixPath, isDir) {
+		if isDir {
+			w.pending[unixPath] = pendingDir{fileRelativePath, fullPath}
+		}
+		return nil
+	}
+	parents := make([]string, 0)
+	for parent := path.Dir(unixPath); parent != "." && parent != "/"; parent = path.Dir(parent) {
+		if _, ok := w.pending[parent]; ok {
+			parents = append(parents, parent)
+		}
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		dir := w.pending[parents[i]]
+		delete(w.pending, parents[i])
+		err := w.onEachFile(dir.relativePath, dir.fullPath)
+		if err != nil {
+			return err
+		}
+	}
+	return w.onEachFile(fileRelativePath, fullPath)
+}
+
+// walk walkDir, relative paths are prefixed by relPrefix
+// followed contains real paths of directories currently walked, it's used to detect cycles when following symlinks
+func (w *walker) walk(walkDir, relPrefix string, followed []string) error {
 	walkFunc := func(fullPath string, f os.FileInfo, err error) error {
-		fileRelativePath, _ := filepath.Rel(dir, fullPath)
+		if ctxErr := w.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		fileRelativePath, _ := filepath.Rel(walkDir, fullPath)
+		fileRelativePath = filepath.Join(relPrefix, fileRelativePath)
 		fileRelativeUnixPath := filepath.ToSlash(fileRelativePath)
 
 		if err != nil && runtime.GOOS == "windows" {
@@ -185,11 +403,11 @@ func (appfiles DirFiles) WalkAppFiles(dir string, onEachFile func(string, string
 			fullPath = windowsPathPrefix + fullPath
 		}
 
-		if fullPath == dir {
+		if fullPath == walkDir {
 			return nil
 		}
 
-		if cfIgnore.FileShouldBeIgnored(fileRelativeUnixPath) {
+		if w.isIgnored(fileRelativeUnixPath, err == nil && f.IsDir()) {
 			if err == nil && f.IsDir() {
 				return filepath.SkipDir
 			}
@@ -200,14 +418,88 @@ func (appfiles DirFiles) WalkAppFiles(dir string, onEachFile func(string, string
 			return err
 		}
 
+		if f.IsDir() {
+			w.loadNestedIgnoreFiles(fullPath, fileRelativeUnixPath)
+		}
+
+		if f.Mode()&os.ModeSymlink != 0 {
+			return w.walkSymlink(fullPath, fileRelativePath, followed)
+		}
+
 		if !f.Mode().IsRegular() && !f.IsDir() {
 			return nil
 		}
 
-		return onEachFile(fileRelativePath, fullPath)
+		return w.visit(fileRelativePath, fullPath, f.IsDir())
 	}
 
-	return filepath.Walk(dir, walkFunc)
+	return filepath.Walk(walkDir, walkFunc)
+}
+
+func (w *walker) walkSymlink(fullPath, fileRelativePath string, followed []string) error {
+	switch w.Symlinks {
+	case SymlinkPreserve:
+		target, err := os.Readlink(fullPath)
+		if err != nil {
+			return err
+		}
+		if !IsRelativeLinkInside(filepath.ToSlash(fileRelativePath), filepath.ToSlash(target)) {
+			return fmt.Errorf("Symlink %s targets %s which is outside of %s", fileRelativePath, target, w.root)
+		}
+		return w.visit(fileRelativePath, fullPath, false)
+	case SymlinkFollow:
+		realPath, err := filepath.EvalSymlinks(fullPath)
+		if err != nil {
+			return err
+		}
+		realPath, err = filepath.Abs(realPath)
+		if err != nil {
+			return err
+		}
+		if !isInside(w.realRoot, realPath) {
+			return fmt.Errorf("Symlink %s resolves to %s which is outside of %s", fileRelativePath, realPath, w.root)
+		}
+		stat, err := os.Stat(realPath)
+		if err != nil {
+			return err
+		}
+		if !stat.IsDir() {
+			if !stat.Mode().IsRegular() {
+				return nil
+			}
+			return w.visit(fileRelativePath, realPath, false)
+		}
+		for _, dir := range followed {
+			if isInside(realPath, dir) {
+				return fmt.Errorf("Symlink %s resolves to %s which creates a cycle", fileRelativePath, realPath)
+			}
+		}
+		err = w.visit(fileRelativePath, realPath, true)
+		if err != nil {
+			return err
+		}
+		w.loadNestedIgnoreFiles(realPath, filepath.ToSlash(fileRelativePath))
+		return w.walk(realPath, fileRelativePath, append(append([]string{}, followed...), realPath))
+	}
+	return nil
+}
+
+// Check if a relative symlink target stays inside the root of its link
+// linkPath is the slash separated path of the link relative to the root
+func IsRelativeLinkInside(linkPath, target string) bool {
+	if target == "" || path.IsAbs(target) || filepath.IsAbs(target) {
+		return false
+	}
+	resolved := path.Join(path.Dir(linkPath), target)
+	return resolved != ".." && !strings.HasPrefix(resolved, "../")
+}
+
+func isInside(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
 }
 
 var ignorerFiles []string = []string{
@@ -216,14 +508,72 @@ var ignorerFiles []string = []string{
 	".zipignore",
 }
 
-func loadIgnoreFile(dir string) IgnoreFiles {
-	fileContents := make([]string, 0)
-	for _, ignorer := range ignorerFiles {
-		b, _ := ioutil.ReadFile(filepath.Join(dir, ignorer))
-		fileContents = append(fileContents, string(b))
+// names of ignore files read in every walked directory, .gitignore has the lowest precedence
+func (appfiles DirFiles) ignorerFiles() []string {
+	if !appfiles.GitIgnore {
+		return ignorerFiles
+	}
+	return append([]string{".gitignore"}, ignorerFiles...)
+}
+
+// load rules from ignore files at the root of dir, .git/info/exclude has a lower precedence than any ignore files
+func (appfiles DirFiles) loadIgnoreFile(dir string) ignoreFile {
+	ignore := NewIgnoreFiles("").(ignoreFile)
+	if appfiles.GitIgnore {
+		b, _ := ioutil.ReadFile(filepath.Join(dir, ".git", "info", "exclude"))
+		ignore = append(ignore, newIgnoreFile(string(b), "")...)
+	}
+	return append(ignore, loadIgnoreFilesInDir(dir, "", appfiles.ignorerFiles())...)
+}
+
+// Load rules from ignore files found in every directories of fsys (e.g.: a zip), as walking a directory does
+// Ignore files inside an ignored directory are not read
+func (appfiles DirFiles) IgnoreFilesFS(fsys fs.FS) (DirIgnoreFiles, error) {
+	ignore := NewIgnoreFiles("").(ignoreFile)
+	if appfiles.GitIgnore {
+		b, err := fs.ReadFile(fsys, ".git/info/exclude")
+		if err == nil {
+			ignore = append(ignore, newIgnoreFile(string(b), "")...)
+		}
+	}
+	ignorers := appfiles.ignorerFiles()
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		base := ""
+		if p != "." {
+			base = p
+			if ignore.PathShouldBeIgnored(base, true) {
+				return fs.SkipDir
+			}
+		}
+		for _, ignorer := range ignorers {
+			b, err := fs.ReadFile(fsys, path.Join(p, ignorer))
+			if err != nil {
+				continue
+			}
+			ignore = append(ignore, newIgnoreFile(string(b), base)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	if len(fileContents) == 0 {
-		return NewIgnoreFiles("")
+	return ignore, nil
+}
+
+func loadIgnoreFilesInDir(dir, base string, ignorers []string) ignoreFile {
+	ignore := ignoreFile{}
+	for _, ignorer := range ignorers {
+		b, err := ioutil.ReadFile(filepath.Join(dir, ignorer))
+		if err != nil {
+			continue
+		}
+		ignore = append(ignore, newIgnoreFile(string(b), base)...)
 	}
-	return NewIgnoreFiles(strings.Join(fileContents, "\n"))
+	return ignore
 }
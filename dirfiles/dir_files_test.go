@@ -1,11 +1,13 @@
 package dirfiles_test
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"testing/fstest"
 
 	"github.com/ArthurHlt/zipper/dirfiles"
 	"github.com/nu7hatch/gouuid"
@@ -34,6 +36,27 @@ func (a WalkAppFileArgs) Equal(other WalkAppFileArgs) bool {
 		a.AbsolutePath() == other.AbsolutePath()
 }
 
+// Files which can only walk without context
+type walkOnlyFiles struct {
+	dirFiles dirfiles.DirFiles
+}
+
+func (f walkOnlyFiles) FilesInDir(dir string) ([]dirfiles.FileFields, error) {
+	return f.dirFiles.AppFilesInDir(dir)
+}
+
+func (f walkOnlyFiles) CopyFiles(appFiles []dirfiles.FileFields, fromDir, toDir string) error {
+	return f.dirFiles.CopyFiles(appFiles, fromDir, toDir)
+}
+
+func (f walkOnlyFiles) CountFiles(directory string) int64 {
+	return f.dirFiles.CountFiles(directory)
+}
+
+func (f walkOnlyFiles) WalkAppFiles(dir string, onEachFile func(string, string) error) error {
+	return f.dirFiles.WalkAppFiles(dir, onEachFile)
+}
+
 var _ = Describe("Files", func() {
 	var appFiles dirfiles.DirFiles
 	var fixturePath string
@@ -68,16 +91,11 @@ var _ = Describe("Files", func() {
 			})
 
 			It("excludes ignored files", func() {
+				// as in git, dir1/child-dir/file3.txt can't be re-included because dir1/child-dir is ignored
 				Expect(paths).To(Equal([]string{
 					"dir1",
-					"dir1/child-dir",
-					"dir1/child-dir/file3.txt",
 					"dir1/file1.txt",
 					"dir2",
-
-					// TODO: this should be excluded.
-					// .cfignore doesn't handle ** patterns right now
-					"dir2/child-dir2",
 				}))
 			})
 		})
@@ -105,6 +123,73 @@ var _ = Describe("Files", func() {
 		})
 	})
 
+	Describe("ContentSha1", func() {
+		var appDir string
+
+		BeforeEach(func() {
+			var err error
+			appDir, err = ioutil.TempDir("", "content-sha1")
+			Expect(err).NotTo(HaveOccurred())
+
+			files, err := appFiles.AppFilesInDir(filepath.Join(fixturePath, "app-copy-test"))
+			Expect(err).NotTo(HaveOccurred())
+			err = appFiles.CopyFiles(files, filepath.Join(fixturePath, "app-copy-test"), appDir)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(appDir)
+		})
+
+		It("gives the same sha1 when nothing changed", func() {
+			sha1, err := appFiles.ContentSha1(appDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			otherSha1, err := appFiles.ContentSha1(appDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(otherSha1).To(Equal(sha1))
+		})
+
+		It("gives a different sha1 when content of the last file changed", func() {
+			sha1, err := appFiles.ContentSha1(appDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			lastFile := filepath.Join(appDir, "dir2", "child-dir2", "grandchild-dir2", "file4.txt")
+			err = ioutil.WriteFile(lastFile, []byte("changed"), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			otherSha1, err := appFiles.ContentSha1(appDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(otherSha1).ToNot(Equal(sha1))
+		})
+
+		It("gives a different sha1 when a file is renamed", func() {
+			sha1, err := appFiles.ContentSha1(appDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = os.Rename(filepath.Join(appDir, "dir1", "file1.txt"), filepath.Join(appDir, "dir1", "file0.txt"))
+			Expect(err).NotTo(HaveOccurred())
+
+			otherSha1, err := appFiles.ContentSha1(appDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(otherSha1).ToNot(Equal(sha1))
+		})
+
+		It("gives the same sha1 when an ignored file changed", func() {
+			err := ioutil.WriteFile(filepath.Join(appDir, ".cfignore"), []byte("ignored.txt"), 0644)
+			Expect(err).NotTo(HaveOccurred())
+			sha1, err := appFiles.ContentSha1(appDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = ioutil.WriteFile(filepath.Join(appDir, "dir1", "ignored.txt"), []byte("ignored"), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			otherSha1, err := appFiles.ContentSha1(appDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(otherSha1).To(Equal(sha1))
+		})
+	})
+
 	Describe("CopyFiles", func() {
 		It("copies only the files specified", func() {
 			copyDir := filepath.Join(fixturePath, "app-copy-test")
@@ -199,6 +284,190 @@ var _ = Describe("Files", func() {
 			}
 		})
 
+		Context("when context is cancelled", func() {
+			It("stops walking and returns context error", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				err := appFiles.WalkAppFilesContext(ctx, filepath.Join(fixturePath, "app-copy-test"), cb)
+				Expect(err).To(Equal(context.Canceled))
+				Expect(actualWalkAppFileArgs).To(BeEmpty())
+			})
+			It("stops walking with files which can only walk without context", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				err := dirfiles.WalkAppFilesContext(ctx, walkOnlyFiles{appFiles}, filepath.Join(fixturePath, "app-copy-test"), cb)
+				Expect(err).To(Equal(context.Canceled))
+				Expect(actualWalkAppFileArgs).To(BeEmpty())
+			})
+		})
+
+		Context("when the given dir contains symlinks", func() {
+			var tmpDir string
+
+			BeforeEach(func() {
+				if runtime.GOOS == "windows" {
+					Skip("This test is only for non-Windows platforms")
+				}
+
+				var err error
+				tmpDir, err = ioutil.TempDir("", "symlinks-test")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(os.MkdirAll(filepath.Join(tmpDir, "app", "dir"), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(tmpDir, "app", "dir", "file.txt"), []byte("content"), 0644)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(tmpDir, "outside.txt"), []byte("outside"), 0644)).To(Succeed())
+				Expect(os.Symlink("dir/file.txt", filepath.Join(tmpDir, "app", "link.txt"))).To(Succeed())
+				Expect(os.Symlink("dir", filepath.Join(tmpDir, "app", "linkdir"))).To(Succeed())
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(tmpDir)
+			})
+
+			walk := func(appFiles dirfiles.DirFiles) ([]string, error) {
+				paths := []string{}
+				err := appFiles.WalkAppFiles(filepath.Join(tmpDir, "app"), func(fileRelativePath, fullPath string) error {
+					paths = append(paths, filepath.ToSlash(fileRelativePath))
+					return nil
+				})
+				return paths, err
+			}
+
+			It("skips them by default", func() {
+				paths, err := walk(dirfiles.DirFiles{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(paths).To(Equal([]string{"dir", "dir/file.txt"}))
+			})
+
+			It("gives them as they are when preserving them", func() {
+				paths, err := walk(dirfiles.DirFiles{Symlinks: dirfiles.SymlinkPreserve})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(paths).To(Equal([]string{"dir", "dir/file.txt", "link.txt", "linkdir"}))
+			})
+
+			It("gives their targets when following them", func() {
+				paths, err := walk(dirfiles.DirFiles{Symlinks: dirfiles.SymlinkFollow})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(paths).To(Equal([]string{"dir", "dir/file.txt", "link.txt", "linkdir", "linkdir/file.txt"}))
+			})
+
+			It("refuses a symlink escaping the directory", func() {
+				Expect(os.Symlink("../outside.txt", filepath.Join(tmpDir, "app", "escape.txt"))).To(Succeed())
+
+				_, err := walk(dirfiles.DirFiles{Symlinks: dirfiles.SymlinkPreserve})
+				Expect(err).To(HaveOccurred())
+
+				_, err = walk(dirfiles.DirFiles{Symlinks: dirfiles.SymlinkFollow})
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("refuses a symlink creating a cycle when following them", func() {
+				Expect(os.Symlink("..", filepath.Join(tmpDir, "app", "dir", "parent"))).To(Succeed())
+
+				_, err := walk(dirfiles.DirFiles{Symlinks: dirfiles.SymlinkFollow})
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("when a filter is given", func() {
+			walk := func(filter *dirfiles.PathFilter) []string {
+				paths := []string{}
+				appFiles := dirfiles.DirFiles{Filter: filter}
+				err := appFiles.WalkAppFiles(filepath.Join(fixturePath, "app-copy-test"), func(fileRelativePath, fullPath string) error {
+					paths = append(paths, filepath.ToSlash(fileRelativePath))
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+				return paths
+			}
+
+			It("gives only included paths and their parent directories", func() {
+				Expect(walk(dirfiles.NewPathFilter([]string{"**/file4.txt"}, nil))).To(Equal([]string{
+					"dir2",
+					"dir2/child-dir2",
+					"dir2/child-dir2/grandchild-dir2",
+					"dir2/child-dir2/grandchild-dir2/file4.txt",
+				}))
+			})
+
+			It("removes excluded paths even when they are included", func() {
+				Expect(walk(dirfiles.NewPathFilter([]string{"dir1/child-dir/"}, []string{"file2.txt"}))).To(Equal([]string{
+					"dir1",
+					"dir1/child-dir",
+					"dir1/child-dir/file3.txt",
+				}))
+			})
+
+			It("gives every paths when filter is nil", func() {
+				Expect(walk(nil)).To(HaveLen(9))
+			})
+		})
+
+		Context("when the given dir contains nested ignore files", func() {
+			var tmpDir string
+
+			BeforeEach(func() {
+				var err error
+				tmpDir, err = ioutil.TempDir("", "nested-ignore-test")
+				Expect(err).NotTo(HaveOccurred())
+
+				files := map[string]string{
+					".zipignore":           "*.log\n",
+					"sub/.zipignore":       "!keep.log\n/local.txt\n",
+					".gitignore":           "*.tmp\n",
+					".git/info/exclude":    "excluded.txt\n",
+					"x.log":                "",
+					"local.txt":            "",
+					"a.tmp":                "",
+					"excluded.txt":         "",
+					"sub/keep.log":         "",
+					"sub/y.log":            "",
+					"sub/local.txt":        "",
+					"sub/deeper/local.txt": "",
+				}
+				for name, content := range files {
+					Expect(os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, name)), 0755)).To(Succeed())
+					Expect(ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644)).To(Succeed())
+				}
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(tmpDir)
+			})
+
+			walk := func(appFiles dirfiles.DirFiles) []string {
+				paths := []string{}
+				err := appFiles.WalkAppFiles(tmpDir, func(fileRelativePath, fullPath string) error {
+					paths = append(paths, filepath.ToSlash(fileRelativePath))
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+				return paths
+			}
+
+			It("applies each ignore file relative to its own directory", func() {
+				Expect(walk(dirfiles.DirFiles{})).To(Equal([]string{
+					"a.tmp",
+					"excluded.txt",
+					"local.txt",
+					"sub",
+					"sub/deeper",
+					"sub/deeper/local.txt",
+					"sub/keep.log",
+				}))
+			})
+
+			It("honours .gitignore and .git/info/exclude when asked", func() {
+				Expect(walk(dirfiles.DirFiles{GitIgnore: true})).To(Equal([]string{
+					"local.txt",
+					"sub",
+					"sub/deeper",
+					"sub/deeper/local.txt",
+					"sub/keep.log",
+				}))
+			})
+		})
+
 		Context("when the given dir contains an untraversable dir", func() {
 			var (
 				untraversableDirName string
@@ -256,4 +525,31 @@ var _ = Describe("Files", func() {
 			})
 		})
 	})
+
+	Describe("IgnoreFilesFS", func() {
+		fsys := fstest.MapFS{
+			".zipignore":     {Data: []byte("*.log\n")},
+			".gitignore":     {Data: []byte("*.tmp\n")},
+			"sub/.zipignore": {Data: []byte("!keep.log\n/local.txt\n")},
+			"sub/keep.log":   {},
+			"sub/local.txt":  {},
+		}
+
+		It("loads ignore files found in the file system relative to their directory", func() {
+			ignore, err := dirfiles.DirFiles{}.IgnoreFilesFS(fsys)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ignore.PathShouldBeIgnored("x.log", false)).To(BeTrue())
+			Expect(ignore.PathShouldBeIgnored("sub/keep.log", false)).To(BeFalse())
+			Expect(ignore.PathShouldBeIgnored("sub/local.txt", false)).To(BeTrue())
+			Expect(ignore.PathShouldBeIgnored("local.txt", false)).To(BeFalse())
+			Expect(ignore.PathShouldBeIgnored("a.tmp", false)).To(BeFalse())
+			Expect(ignore.PathShouldBeIgnored(".zipignore", false)).To(BeTrue())
+		})
+
+		It("honours .gitignore when asked", func() {
+			ignore, err := dirfiles.DirFiles{GitIgnore: true}.IgnoreFilesFS(fsys)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ignore.PathShouldBeIgnored("a.tmp", false)).To(BeTrue())
+		})
+	})
 })
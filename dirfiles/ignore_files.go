@@ -9,64 +9,120 @@ type IgnoreFiles interface {
 	FileShouldBeIgnored(path string) bool
 }
 
+// Ignore rules which need to know if path is a directory (e.g.: for directory only patterns like "build/")
+// Walking a directory uses PathShouldBeIgnored instead of FileShouldBeIgnored when ignore rules implement it
+type DirIgnoreFiles interface {
+	IgnoreFiles
+	PathShouldBeIgnored(path string, isDir bool) bool
+}
+
+// Create ignore rules from an ignore file content, rules follow .gitignore format (see https://git-scm.com/docs/gitignore)
+// Commonly ignored files (.git, .zipignore, ...) are ignored by default
 func NewIgnoreFiles(text string) IgnoreFiles {
-	patterns := []ignorePattern{}
-	lines := strings.Split(text, "\n")
-	lines = append(defaultIgnoreLines, lines...)
+	return append(newIgnoreFile(strings.Join(defaultIgnoreLines, "\n"), ""), newIgnoreFile(text, "")...)
+}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
+// Create ignore rules from an ignore file content found in directory base (slash separated and relative to walked directory)
+// patterns only apply to paths inside base as for a .gitignore in a subdirectory
+func NewIgnoreFilesInDir(text string, base string) IgnoreFiles {
+	return newIgnoreFile(text, base)
+}
+
+func newIgnoreFile(text string, base string) ignoreFile {
+	base = strings.Trim(path.Clean("/"+base), "/")
+	patterns := ignoreFile{}
+	for _, line := range strings.Split(text, "\n") {
+		line = trimTrailingSpaces(strings.TrimSuffix(line, "\r"))
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-
-		ignore := true
+		pattern := ignorePattern{exclude: true, base: base}
 		if strings.HasPrefix(line, "!") {
 			line = line[1:]
-			ignore = false
+			pattern.exclude = false
 		}
-
-		for _, p := range globsForPattern(path.Clean(line)) {
-			patterns = append(patterns, ignorePattern{ignore, p})
+		if strings.HasSuffix(line, "/") {
+			line = strings.TrimRight(line, "/")
+			pattern.dirOnly = true
+		}
+		if line == "" {
+			continue
 		}
+		// pattern without slash (other than a trailing one) matches at any level below base
+		pattern.basename = !strings.Contains(line, "/")
+		pattern.pattern = strings.TrimPrefix(line, "/")
+		patterns = append(patterns, pattern)
 	}
+	return patterns
+}
 
-	return ignoreFile(patterns)
+// remove trailing spaces which are not escaped with a backslash
+func trimTrailingSpaces(line string) string {
+	end := len(line)
+	for end > 0 && line[end-1] == ' ' {
+		backslashes := 0
+		for i := end - 2; i >= 0 && line[i] == '\\'; i-- {
+			backslashes++
+		}
+		if backslashes%2 == 1 {
+			break
+		}
+		end--
+	}
+	return line[:end]
 }
 
 func (ignore ignoreFile) FileShouldBeIgnored(path string) bool {
-	result := false
+	return ignore.PathShouldBeIgnored(path, false)
+}
 
-	for _, pattern := range ignore {
-		if strings.HasPrefix(pattern.glob.String(), "/") && !strings.HasPrefix(path, "/") {
-			path = "/" + path
+// Check if path is ignored, as in git a path is ignored when one of its parent directories is ignored
+// and an ignored directory content can't be re-included
+func (ignore ignoreFile) PathShouldBeIgnored(p string, isDir bool) bool {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return false
+	}
+	for i := 0; i < len(p); i++ {
+		if p[i] == '/' && ignore.matches(p[:i], true) {
+			return true
 		}
+	}
+	return ignore.matches(p, isDir)
+}
 
-		if pattern.glob.Match(path) {
-			result = pattern.exclude
+// last pattern matching path decides if path is ignored
+func (ignore ignoreFile) matches(p string, isDir bool) bool {
+	for i := len(ignore) - 1; i >= 0; i-- {
+		if ignore[i].match(p, isDir) {
+			return ignore[i].exclude
 		}
 	}
-
-	return result
+	return false
 }
 
-func globsForPattern(pattern string) (globs []Glob) {
-	globs = append(globs, MustCompileGlob(pattern))
-	globs = append(globs, MustCompileGlob(path.Join(pattern, "*")))
-	globs = append(globs, MustCompileGlob(path.Join(pattern, "**", "*")))
-
-	if !strings.HasPrefix(pattern, "/") {
-		globs = append(globs, MustCompileGlob(path.Join("**", pattern)))
-		globs = append(globs, MustCompileGlob(path.Join("**", pattern, "*")))
-		globs = append(globs, MustCompileGlob(path.Join("**", pattern, "**", "*")))
+func (pattern ignorePattern) match(p string, isDir bool) bool {
+	if pattern.dirOnly && !isDir {
+		return false
 	}
-
-	return
+	if pattern.base != "" {
+		if !strings.HasPrefix(p, pattern.base+"/") {
+			return false
+		}
+		p = p[len(pattern.base)+1:]
+	}
+	if pattern.basename {
+		return wildmatch(pattern.pattern, path.Base(p))
+	}
+	return wildmatch(pattern.pattern, p)
 }
 
 type ignorePattern struct {
-	exclude bool
-	glob    Glob
+	exclude  bool
+	pattern  string
+	base     string
+	dirOnly  bool
+	basename bool
 }
 
 type ignoreFile []ignorePattern
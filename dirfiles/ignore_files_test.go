@@ -1,6 +1,8 @@
 package dirfiles_test
 
 import (
+	"fmt"
+
 	. "github.com/ArthurHlt/zipper/dirfiles"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -59,6 +61,20 @@ stuff/exclude.c`)
 		Expect(ignore.FileShouldBeIgnored("stuff/include.c")).To(BeFalse())
 	})
 
+	It("excludes directories only with patterns with a trailing slash", func() {
+		ignore := NewIgnoreFiles(`build/`).(DirIgnoreFiles)
+		Expect(ignore.PathShouldBeIgnored("build", true)).To(BeTrue())
+		Expect(ignore.PathShouldBeIgnored("build", false)).To(BeFalse())
+		Expect(ignore.FileShouldBeIgnored("build/the-file")).To(BeTrue())
+	})
+
+	It("applies patterns of an ignore file in a subdirectory relative to this subdirectory", func() {
+		ignore := NewIgnoreFilesInDir(`/local.txt`, "sub")
+		Expect(ignore.FileShouldBeIgnored("sub/local.txt")).To(BeTrue())
+		Expect(ignore.FileShouldBeIgnored("local.txt")).To(BeFalse())
+		Expect(ignore.FileShouldBeIgnored("sub/deeper/local.txt")).To(BeFalse())
+	})
+
 	It("ignores certain commonly ingored files by default", func() {
 		ignore := NewIgnoreFiles(``)
 		Expect(ignore.FileShouldBeIgnored(".git/objects")).To(BeTrue())
@@ -67,4 +83,132 @@ stuff/exclude.c`)
 		Expect(ignore.FileShouldBeIgnored(".git/objects")).To(BeFalse())
 	})
 
+	// expectations have been given by git (git ls-files --others --ignored --exclude-standard for files
+	// and git check-ignore for directories) with pattern as .gitignore content
+	Describe("git conformance", func() {
+		type checkIgnore struct {
+			path    string
+			isDir   bool
+			ignored bool
+		}
+		for _, c := range []struct {
+			pattern string
+			checks  []checkIgnore
+		}{
+			{"*.o", []checkIgnore{
+				{"a.o", false, true},
+				{"dir/b.o", false, true},
+				{"dir/c.c", false, false},
+				{"o", false, false},
+			}},
+			{"/root.txt", []checkIgnore{
+				{"root.txt", false, true},
+				{"dir/root.txt", false, false},
+			}},
+			{"build/", []checkIgnore{
+				{"build/out.bin", false, true},
+				{"dir/build/out.bin", false, true},
+				{"dir/build", true, true},
+			}},
+			{"build/\n", []checkIgnore{
+				{"build", true, true},
+			}},
+			{"doc/*.txt", []checkIgnore{
+				{"doc/a.txt", false, true},
+				{"doc/sub/b.txt", false, false},
+				{"other/doc/a.txt", false, false},
+			}},
+			{"doc/**/*.txt", []checkIgnore{
+				{"doc/a.txt", false, true},
+				{"doc/sub/b.txt", false, true},
+				{"doc/sub/deep/c.txt", false, true},
+				{"other/doc/a.txt", false, false},
+			}},
+			{"**/logs", []checkIgnore{
+				{"logs/a", false, true},
+				{"dir/logs/b", false, true},
+				{"dir/logsx", false, false},
+			}},
+			{"**/logs/debug.log", []checkIgnore{
+				{"logs/debug.log", false, true},
+				{"a/b/logs/debug.log", false, true},
+				{"logs/sub/debug.log", false, false},
+			}},
+			{"abc/**", []checkIgnore{
+				{"abc/x", false, true},
+				{"abc/y/z", false, true},
+				{"abcd/x", false, false},
+			}},
+			{"a/**/b", []checkIgnore{
+				{"a/b", false, true},
+				{"a/x/b", false, true},
+				{"a/x/y/b", false, true},
+				{"a/xb", false, false},
+			}},
+			{"*\n!*.txt\n!*/", []checkIgnore{
+				{"a.bin", false, true},
+				{"a.txt", false, false},
+				{"d/b.txt", false, false},
+				{"d/b.bin", false, true},
+			}},
+			{"dir\n!dir/keep.txt", []checkIgnore{
+				{"dir/keep.txt", false, true},
+				{"dir/other.txt", false, true},
+			}},
+			{"dir/*\n!dir/keep.txt", []checkIgnore{
+				{"dir/keep.txt", false, false},
+				{"dir/other.txt", false, true},
+			}},
+			{"\\#hash\n#comment", []checkIgnore{
+				{"#hash", false, true},
+				{"#comment", false, false},
+			}},
+			{"\\!bang", []checkIgnore{
+				{"!bang", false, true},
+				{"bang", false, false},
+			}},
+			{"trailing\\ ", []checkIgnore{
+				{"trailing ", false, true},
+				{"trailing", false, false},
+			}},
+			{"trailing   ", []checkIgnore{
+				{"trailing", false, true},
+				{"trailing x", false, false},
+			}},
+			{"file?.txt", []checkIgnore{
+				{"file1.txt", false, true},
+				{"file12.txt", false, false},
+				{"dir/fileA.txt", false, true},
+			}},
+			{"file[0-9].txt", []checkIgnore{
+				{"file1.txt", false, true},
+				{"filea.txt", false, false},
+			}},
+			{"file[!0-9].txt", []checkIgnore{
+				{"file1.txt", false, false},
+				{"filea.txt", false, true},
+			}},
+			{"file[[:upper:]].txt", []checkIgnore{
+				{"fileA.txt", false, true},
+				{"filea.txt", false, false},
+			}},
+			{"foo*bar", []checkIgnore{
+				{"foobar", false, true},
+				{"fooxbar", false, true},
+				{"dir/fooxbar", false, true},
+			}},
+			{"a/*/c", []checkIgnore{
+				{"a/b/c", false, true},
+				{"a/b/x/c", false, false},
+			}},
+		} {
+			c := c
+			It(fmt.Sprintf("ignores paths as git does with %q", c.pattern), func() {
+				ignore := NewIgnoreFiles(c.pattern).(DirIgnoreFiles)
+				for _, check := range c.checks {
+					Expect(ignore.PathShouldBeIgnored(check.path, check.isDir)).To(Equal(check.ignored), check.path)
+				}
+			})
+		}
+	})
 })
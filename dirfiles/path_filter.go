@@ -0,0 +1,48 @@
+package dirfiles
+
+import (
+	"strings"
+)
+
+// Filter paths with include and exclude patterns given by caller instead of ignore files,
+// patterns follow .gitignore format and are relative to walked directory
+// A nil filter includes every paths
+type PathFilter struct {
+	includes ignoreFile
+	excludes ignoreFile
+}
+
+// Create a filter from include and exclude patterns
+// When include patterns are given, only paths matching one of them (or inside a matching directory) are kept
+// Paths matching an exclude pattern are always removed, even when they are included
+func NewPathFilter(includes []string, excludes []string) *PathFilter {
+	if len(includes) == 0 && len(excludes) == 0 {
+		return nil
+	}
+	return &PathFilter{
+		includes: newIgnoreFile(strings.Join(includes, "\n"), ""),
+		excludes: newIgnoreFile(strings.Join(excludes, "\n"), ""),
+	}
+}
+
+// Check if path matches an exclude pattern or is inside an excluded directory
+func (f *PathFilter) Excluded(path string, isDir bool) bool {
+	if f == nil {
+		return false
+	}
+	return f.excludes.PathShouldBeIgnored(path, isDir)
+}
+
+// Check if path matches an include pattern or is inside an included directory, every paths are included without include patterns
+// A directory which is not included can still contain included paths
+func (f *PathFilter) Included(path string, isDir bool) bool {
+	if f == nil || len(f.includes) == 0 {
+		return true
+	}
+	return f.includes.PathShouldBeIgnored(path, isDir)
+}
+
+// Check if a path must be kept
+func (f *PathFilter) Match(path string, isDir bool) bool {
+	return !f.Excluded(path, isDir) && f.Included(path, isDir)
+}
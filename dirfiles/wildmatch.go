@@ -0,0 +1,244 @@
+package dirfiles
+
+// Port of git wildmatch (https://github.com/git/git/blob/master/wildmatch.c) with WM_PATHNAME flag:
+// '*' and '?' don't match '/', '**' matches across directories only when it is a full path component
+// and '[...]' classes (including [:alpha:] like classes) never match '/'
+
+const (
+	wmMatch           = 0
+	wmNoMatch         = 1
+	wmAbortAll        = -1
+	wmAbortToStarStar = -2
+)
+
+// Check if text matches pattern with gitignore rules
+func wildmatch(pattern, text string) bool {
+	return dowild([]byte(pattern), []byte(text)) == wmMatch
+}
+
+func dowild(p, text []byte) int {
+	pi, ti := 0, 0
+	for ; pi < len(p); pi, ti = pi+1, ti+1 {
+		pCh := p[pi]
+		if ti >= len(text) && pCh != '*' {
+			return wmAbortAll
+		}
+		var tCh byte
+		if ti < len(text) {
+			tCh = text[ti]
+		}
+		switch pCh {
+		case '\\':
+			// literal match with following character
+			pi++
+			if pi >= len(p) {
+				return wmNoMatch
+			}
+			if tCh != p[pi] {
+				return wmNoMatch
+			}
+		case '?':
+			if tCh == '/' {
+				return wmNoMatch
+			}
+		case '*':
+			pi++
+			matchSlash := false
+			if pi < len(p) && p[pi] == '*' {
+				prevPi := pi - 1
+				for pi < len(p) && p[pi] == '*' {
+					pi++
+				}
+				if (prevPi == 0 || p[prevPi-1] == '/') &&
+					(pi == len(p) || p[pi] == '/' || (p[pi] == '\\' && pi+1 < len(p) && p[pi+1] == '/')) {
+					// "**/" can match zero directory
+					if pi < len(p) && p[pi] == '/' && dowild(p[pi+1:], text[ti:]) == wmMatch {
+						return wmMatch
+					}
+					matchSlash = true
+				}
+			}
+			if pi == len(p) {
+				// trailing "**" matches everything, trailing "*" matches only if there are no more slashes
+				if !matchSlash {
+					for _, c := range text[ti:] {
+						if c == '/' {
+							return wmNoMatch
+						}
+					}
+				}
+				return wmMatch
+			}
+			if !matchSlash && p[pi] == '/' {
+				// without "**", "*/" matches text up to next slash which is consumed by the loop
+				slash := -1
+				for i := ti; i < len(text); i++ {
+					if text[i] == '/' {
+						slash = i
+						break
+					}
+				}
+				if slash == -1 {
+					return wmNoMatch
+				}
+				ti = slash
+				continue
+			}
+			for ; ti < len(text); ti++ {
+				matched := dowild(p[pi:], text[ti:])
+				if matched != wmNoMatch {
+					if !matchSlash || matched != wmAbortToStarStar {
+						return matched
+					}
+				} else if !matchSlash && text[ti] == '/' {
+					return wmAbortToStarStar
+				}
+			}
+			return wmAbortAll
+		case '[':
+			var ok bool
+			pi, ok = matchClass(p, pi, tCh)
+			if pi < 0 {
+				return wmAbortAll
+			}
+			if !ok || tCh == '/' {
+				return wmNoMatch
+			}
+		default:
+			if tCh != pCh {
+				return wmNoMatch
+			}
+		}
+	}
+	if ti < len(text) {
+		return wmNoMatch
+	}
+	return wmMatch
+}
+
+// match character class starting at p[start] ('['), it returns index of closing ']'
+// and if tCh is matched, index is negative when class is malformed
+func matchClass(p []byte, start int, tCh byte) (int, bool) {
+	pi := start + 1
+	at := func(i int) byte {
+		if i < len(p) {
+			return p[i]
+		}
+		return 0
+	}
+	pCh := at(pi)
+	if pCh == '^' {
+		pCh = '!'
+	}
+	negated := pCh == '!'
+	if negated {
+		pi++
+		pCh = at(pi)
+	}
+	var prevCh byte
+	matched := false
+	for {
+		if pCh == 0 {
+			return -1, false
+		}
+		switch {
+		case pCh == '\\':
+			pi++
+			pCh = at(pi)
+			if pCh == 0 {
+				return -1, false
+			}
+			if tCh == pCh {
+				matched = true
+			}
+		case pCh == '-' && prevCh != 0 && at(pi+1) != 0 && at(pi+1) != ']':
+			pi++
+			pCh = at(pi)
+			if pCh == '\\' {
+				pi++
+				pCh = at(pi)
+				if pCh == 0 {
+					return -1, false
+				}
+			}
+			if tCh <= pCh && tCh >= prevCh {
+				matched = true
+			}
+			pCh = 0
+		case pCh == '[' && at(pi+1) == ':':
+			s := pi + 2
+			pi = s
+			for at(pi) != 0 && at(pi) != ']' {
+				pi++
+			}
+			if at(pi) == 0 {
+				return -1, false
+			}
+			if pi-s-1 < 0 || p[pi-1] != ':' {
+				// not a "[:class:]", treat "[" as a normal character
+				pi = s - 2
+				pCh = '['
+				if tCh == pCh {
+					matched = true
+				}
+				break
+			}
+			isClass, known := charClass(string(p[s:pi-1]), tCh)
+			if !known {
+				return -1, false
+			}
+			if isClass {
+				matched = true
+			}
+			pCh = 0
+		default:
+			if tCh == pCh {
+				matched = true
+			}
+		}
+		prevCh = pCh
+		pi++
+		pCh = at(pi)
+		if pCh == ']' {
+			break
+		}
+	}
+	return pi, matched != negated
+}
+
+// check if c is in posix class name, known is false for an unknown class
+func charClass(name string, c byte) (isClass bool, known bool) {
+	isUpper := c >= 'A' && c <= 'Z'
+	isLower := c >= 'a' && c <= 'z'
+	isDigit := c >= '0' && c <= '9'
+	isAlpha := isUpper || isLower
+	isPrint := c >= 0x20 && c < 0x7F
+	isSpace := c == ' ' || (c >= '\t' && c <= '\r')
+	switch name {
+	case "alnum":
+		return isAlpha || isDigit, true
+	case "alpha":
+		return isAlpha, true
+	case "blank":
+		return c == ' ' || c == '\t', true
+	case "cntrl":
+		return c < 0x20 || c == 0x7F, true
+	case "digit":
+		return isDigit, true
+	case "graph":
+		return isPrint && c != ' ', true
+	case "lower":
+		return isLower, true
+	case "print":
+		return isPrint, true
+	case "punct":
+		return isPrint && c != ' ' && !isAlpha && !isDigit, true
+	case "space":
+		return isSpace, true
+	case "upper":
+		return isUpper, true
+	case "xdigit":
+		return isDigit || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F'), true
+	}
+	return false, false
+}
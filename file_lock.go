@@ -0,0 +1,43 @@
+package zipper
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// interval between two attempts to take a lock already held by another process
+const lockRetryInterval = 100 * time.Millisecond
+
+// Take an exclusive lock on file at path, shared between processes, until returned unlock function is called
+// Waiting for lock is aborted when context is cancelled, lock is released by system if process exits
+func lockFile(ctx context.Context, path string) (func() error, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if locked {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+	return func() error {
+		err := unlockFile(f)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}, nil
+}
@@ -0,0 +1,475 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ArthurHlt/zipper/dirfiles"
+)
+
+// Converter from an archive format to zip, used by CompressProcessor
+// Compressed content (gzip, bzip2, xz, zstd, lz4 or any format of a Decompressor) is decompressed before being given to converters
+type FormatConverter interface {
+	// Detect if content is in the format handled by this converter
+	// header contains first bytes of the content (up to 512 bytes) and name the file name
+	// (without compression extension when content has been decompressed, e.g.: app.tar for app.tar.gz or app.tgz)
+	Detect(header []byte, name string) bool
+	// Write every files from content to zip writer, zip writer is closed by caller
+	Convert(r io.Reader, w *zip.Writer) error
+}
+
+// Retrieve built-in format converters
+func DefaultFormatConverters() []FormatConverter {
+	return []FormatConverter{
+		ZipConverter{},
+		TarConverter{},
+	}
+}
+
+// Format converter which gives a zip directly from content instead of writing its files one by one
+// (e.g.: content which is already a zip), CompressProcessor calls ToZip instead of Convert on it
+type ZipFormatConverter interface {
+	FormatConverter
+	// Create zip from content, size is UnknownSize when it's not known (e.g.: content has been decompressed)
+	ToZip(src *Source, r io.ReadCloser, size int64) (ZipReadCloser, error)
+}
+
+// Keep zip content as it is, it is only rewritten, without recompressing entries, to remove entries ignored by
+// ignore files inside it (see SetCtxArchiveIgnore) or by include and exclude patterns
+type ZipConverter struct {
+}
+
+func (c ZipConverter) Detect(header []byte, name string) bool {
+	return HasExtFile(name, ZIP_FILE_EXT...) || isZipHeader(header)
+}
+
+func (c ZipConverter) Convert(r io.Reader, zipWriter *zip.Writer) error {
+	return c.ConvertSource(NewSource(""), r, zipWriter)
+}
+
+// zip entries must be read from the end of the zip, content is buffered in a temp file
+func (c ZipConverter) ConvertSource(src *Source, r io.Reader, zipWriter *zip.Writer) error {
+	file, size, clean, err := zipInFile(NewZipFile(ioutil.NopCloser(r), UnknownSize, func() error {
+		return nil
+	}))
+	if err != nil {
+		return err
+	}
+	defer clean()
+	zipReader, err := zip.NewReader(file, size)
+	if err != nil {
+		return err
+	}
+	return copyZipEntries(src.Context(), zipReader, zipWriter, func(name string, isDir bool) bool {
+		return true
+	})
+}
+
+// When size is not known and source is not streamed, zip is buffered in a temp file to give its size
+func (c ZipConverter) ToZip(src *Source, r io.ReadCloser, size int64) (ZipReadCloser, error) {
+	if CtxArchiveIgnore(src) || CtxPathFilter(src) != nil {
+		return filterZip(src, NewZipFile(r, size, func() error {
+			return nil
+		}))
+	}
+	if size != UnknownSize || CtxStreaming(src) {
+		return NewZipFile(r, size, func() error {
+			return nil
+		}), nil
+	}
+	return NewTempZipFile("processor-zipper", func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	}, r.Close)
+}
+
+// rewrite zip without entries removed by ignore files inside zip (when asked) and by include and exclude patterns
+// entries are copied without being recompressed
+func filterZip(src *Source, zipFile ZipReadCloser) (*ZipFile, error) {
+	file, size, clean, err := zipInFile(zipFile)
+	if err != nil {
+		return nil, err
+	}
+	zipReader, err := zip.NewReader(file, size)
+	if err != nil {
+		clean()
+		return nil, err
+	}
+	var ignore dirfiles.DirIgnoreFiles
+	if CtxArchiveIgnore(src) {
+		ignore, err = dirfiles.DirFiles{GitIgnore: CtxGitIgnore(src)}.IgnoreFilesFS(zipReader)
+		if err != nil {
+			clean()
+			return nil, err
+		}
+	}
+	filter := CtxPathFilter(src)
+	return makeZipFile(src, "processor-zipper", func(w io.Writer) error {
+		zipWriter := zip.NewWriter(w)
+		defer zipWriter.Close()
+		err := copyZipEntries(src.Context(), zipReader, zipWriter, func(name string, isDir bool) bool {
+			return (ignore == nil || !ignore.PathShouldBeIgnored(name, isDir)) && filter.Match(name, isDir)
+		})
+		if err != nil {
+			return err
+		}
+		return zipWriter.Close()
+	}, clean)
+}
+
+// copy entries of zip reader kept by keep function without recompressing them
+func copyZipEntries(ctx context.Context, zipReader *zip.Reader, zipWriter *zip.Writer, keep func(name string, isDir bool) bool) error {
+	for _, file := range zipReader.File {
+		isDir := file.FileInfo().IsDir()
+		if !keep(strings.TrimSuffix(file.Name, "/"), isDir) {
+			continue
+		}
+		if isDir {
+			// directories can have an empty compressed stream as content which can't be written back
+			header := file.FileHeader
+			_, err := zipWriter.CreateHeader(&header)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		fw, err := zipWriter.CreateRaw(&file.FileHeader)
+		if err != nil {
+			return err
+		}
+		rawReader, err := file.OpenRaw()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, NewContextReader(ctx, rawReader))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Format converter which needs options set in source context (e.g.: symlink mode)
+// CompressProcessor calls ConvertSource instead of Convert on it
+type SourceFormatConverter interface {
+	FormatConverter
+	ConvertSource(src *Source, r io.Reader, w *zip.Writer) error
+}
+
+// built-in format converter which writes entries straight in output format set in source context (see CtxOutputFormat)
+type entryFormatConverter interface {
+	convertEntries(src *Source, r io.Reader, w EntryWriter) error
+}
+
+// Convert tar to zip, when tar has a root folder it is removed from paths
+// Symlinks and hard links are handled as set in source context (see SetCtxSymlinkMode)
+type TarConverter struct {
+}
+
+func (c TarConverter) Detect(header []byte, name string) bool {
+	return HasExtFile(name, TAR_FILE_EXT...) || isTarHeader(header)
+}
+
+func (c TarConverter) Convert(r io.Reader, zipWriter *zip.Writer) error {
+	return c.ConvertSource(NewSource(""), r, zipWriter)
+}
+
+func (c TarConverter) ConvertSource(src *Source, r io.Reader, zipWriter *zip.Writer) error {
+	return c.convertEntries(src, r, zipWriter)
+}
+
+func (c TarConverter) convertEntries(src *Source, r io.Reader, zipWriter EntryWriter) error {
+	symlinkMode := CtxSymlinkMode(src)
+	if symlinkMode == SymlinkFollow || CtxArchiveIgnore(src) {
+		return c.convertExtracted(src, r, zipWriter)
+	}
+	filter := CtxPathFilter(src)
+	// directories not included by filter, they are written only before an included entry inside them
+	pending := make(map[string]*zip.FileHeader)
+	tarReader := tar.NewReader(r)
+	hasRootFolder := false
+	i := 0
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		fileInfo := header.FileInfo()
+		if i == 0 && fileInfo.IsDir() {
+			hasRootFolder = true
+			continue
+		}
+		i++
+		name := tarEntryName(header.Name, hasRootFolder)
+		filterPath := strings.TrimSuffix(name, "/")
+		if filter.Excluded(filterPath, fileInfo.IsDir()) {
+			continue
+		}
+		if header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeLink {
+			if symlinkMode == SymlinkSkip || !filter.Included(filterPath, false) {
+				continue
+			}
+			err = writePendingDirs(zipWriter, pending, filterPath)
+			if err != nil {
+				return err
+			}
+			err = c.writeLink(zipWriter, header, name, hasRootFolder)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		zipHeader, err := zip.FileInfoHeader(fileInfo)
+		if err != nil {
+			return err
+		}
+		zipHeader.Name = name
+		if !filter.Included(filterPath, fileInfo.IsDir()) {
+			if fileInfo.IsDir() {
+				pending[filterPath] = zipHeader
+			}
+			continue
+		}
+		if !fileInfo.IsDir() {
+			zipHeader.Method = zip.Deflate
+		}
+		err = writePendingDirs(zipWriter, pending, filterPath)
+		if err != nil {
+			return err
+		}
+		fw, err := zipWriter.CreateHeader(zipHeader)
+		if err != nil {
+			return err
+		}
+		if fileInfo.IsDir() {
+			continue
+		}
+		_, err = io.Copy(fw, tarReader)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write pending directories which are parents of p, from the top one
+func writePendingDirs(zipWriter EntryWriter, pending map[string]*zip.FileHeader, p string) error {
+	parents := make([]string, 0)
+	for parent := path.Dir(p); parent != "." && parent != "/"; parent = path.Dir(parent) {
+		if _, ok := pending[parent]; ok {
+			parents = append(parents, parent)
+		}
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		_, err := zipWriter.CreateHeader(pending[parents[i]])
+		if err != nil {
+			return err
+		}
+		delete(pending, parents[i])
+	}
+	return nil
+}
+
+// write a symlink entry with its target as content, hard links are written as relative symlinks
+func (c TarConverter) writeLink(zipWriter EntryWriter, header *tar.Header, name string, hasRootFolder bool) error {
+	target := header.Linkname
+	if header.Typeflag == tar.TypeLink {
+		rel, err := filepath.Rel(filepath.FromSlash(path.Dir(name)), filepath.FromSlash(tarEntryName(target, hasRootFolder)))
+		if err != nil {
+			return err
+		}
+		target = filepath.ToSlash(rel)
+	}
+	if !dirfiles.IsRelativeLinkInside(name, target) {
+		return fmt.Errorf("Symlink %s targets %s which is outside of archive", name, header.Linkname)
+	}
+	zipHeader := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: header.ModTime,
+	}
+	zipHeader.SetMode(os.ModeSymlink | 0777)
+	fw, err := zipWriter.CreateHeader(zipHeader)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write([]byte(target))
+	return err
+}
+
+// links can target any entry in the archive, archive is extracted in a temp dir to follow them
+// extract tar in a temporary directory to walk it as a local directory,
+// this let symlinks be followed and ignore files found inside tar be honoured
+func (c TarConverter) convertExtracted(src *Source, r io.Reader, zipWriter EntryWriter) error {
+	tmpDir, err := ioutil.TempDir("", "tar-zipper")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	err = c.extract(r, tmpDir)
+	if err != nil {
+		return err
+	}
+	appfiles := dirfiles.DirFiles{
+		Symlinks: CtxSymlinkMode(src),
+		Filter:   CtxPathFilter(src),
+	}
+	walkFunc := func(fileName string, fullPath string) error {
+		return writeWalkedFile(src.Context(), zipWriter, fileName, fullPath)
+	}
+	if CtxArchiveIgnore(src) {
+		appfiles.GitIgnore = CtxGitIgnore(src)
+		return appfiles.WalkAppFilesContext(src.Context(), tmpDir, walkFunc)
+	}
+	return appfiles.WalkFilesContext(src.Context(), tmpDir, nil, walkFunc)
+}
+
+func (c TarConverter) extract(r io.Reader, dir string) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	tarReader := tar.NewReader(r)
+	hasRootFolder := false
+	// directories modification times are set at the end, extracting files inside them change it
+	dirTimes := make(map[string]time.Time)
+	i := 0
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		fileInfo := header.FileInfo()
+		if i == 0 && fileInfo.IsDir() {
+			hasRootFolder = true
+			continue
+		}
+		i++
+		name := tarEntryName(header.Name, hasRootFolder)
+		if name == "" {
+			continue
+		}
+		target, err := extractPath(realDir, name)
+		if err != nil {
+			return err
+		}
+		if stat, err := os.Lstat(target); err == nil && !stat.IsDir() {
+			err = os.Remove(target)
+			if err != nil {
+				return err
+			}
+		}
+		if !fileInfo.IsDir() {
+			err = os.MkdirAll(filepath.Dir(target), 0755)
+			if err != nil {
+				return err
+			}
+		}
+		switch {
+		case fileInfo.IsDir():
+			err = os.MkdirAll(target, 0755)
+		case header.Typeflag == tar.TypeSymlink:
+			err = os.Symlink(header.Linkname, target)
+		case header.Typeflag == tar.TypeLink:
+			var linkTarget string
+			linkTarget, err = extractPath(realDir, tarEntryName(header.Linkname, hasRootFolder))
+			if err == nil {
+				err = os.Link(linkTarget, target)
+			}
+		case fileInfo.Mode().IsRegular():
+			err = extractFile(tarReader, target, fileInfo.Mode().Perm())
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fileInfo.IsDir() {
+			dirTimes[target] = header.ModTime
+			continue
+		}
+		if header.Typeflag != tar.TypeSymlink {
+			err = os.Chtimes(target, header.ModTime, header.ModTime)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for dir, modTime := range dirTimes {
+		err = os.Chtimes(dir, modTime, modTime)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(r io.Reader, target string, perm os.FileMode) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Retrieve path where an archive entry must be extracted in dir
+// entry which would be extracted outside of dir, directly or through a symlink, is refused
+func extractPath(dir, name string) (string, error) {
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("Archive entry %s is outside of archive", name)
+	}
+	target := filepath.Join(dir, filepath.FromSlash(clean))
+	existing := filepath.Dir(target)
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	realExisting, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(dir, realExisting)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Archive entry %s is extracted outside of archive through a symlink", name)
+	}
+	return target, nil
+}
+
+// name of a tar entry without the root folder when there is one
+func tarEntryName(name string, hasRootFolder bool) string {
+	if !hasRootFolder {
+		return name
+	}
+	splitFile := strings.Split(name, "/")
+	return strings.Join(splitFile[1:], "/")
+}
+
+func isTarHeader(header []byte) bool {
+	if len(header) < 262 {
+		return false
+	}
+	return string(header[257:262]) == "ustar"
+}
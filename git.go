@@ -1,21 +1,33 @@
 package zipper
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/whilp/git-urls"
 	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/sideband"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
 	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
-	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type GitHandler struct {
@@ -24,6 +36,17 @@ type GitHandler struct {
 
 var scpSyntax = regexp.MustCompile(`^([a-zA-Z0-9_]+@)?([a-zA-Z0-9._-]+):(.*)$`)
 
+var hashRegex = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+
+// Convert an url in scp-like syntax (e.g.: git@github.com:ArthurHlt/zipper.git) to an ssh url, other urls are kept as they are
+func scpToSshUrl(rawUrl string) string {
+	if strings.Contains(rawUrl, "://") || !scpSyntax.MatchString(rawUrl) {
+		return rawUrl
+	}
+	matches := scpSyntax.FindStringSubmatch(rawUrl)
+	return "ssh://" + matches[1] + matches[2] + "/" + strings.TrimPrefix(matches[3], "/")
+}
+
 func NewGitHandler() *GitHandler {
 	customClient := &http.Client{
 		Transport: &http.Transport{
@@ -37,38 +60,123 @@ func NewGitHandler() *GitHandler {
 
 func (h GitHandler) Zip(src *Source) (ZipReadCloser, error) {
 	h.setHttpClient(src)
-	path := src.Path
+	gitUtils, err := h.makeGitUtils("", src.Path, CtxGitSshOptions(src))
+	if err != nil {
+		return nil, err
+	}
+	h.setCredentials(src, gitUtils)
+	gitUtils.CacheDir = CtxGitCacheDir(src)
+	gitUtils.Submodules = gitUtils.Submodules || CtxGitSubmodules(src)
+	gitUtils.Lfs = gitUtils.Lfs || CtxGitLfs(src)
+	var zipFile ZipReadCloser
+	err = gitUtils.withProvidedAuth(src.Context(), func(g GitUtils) error {
+		var zipErr error
+		zipFile, zipErr = h.zipRepo(src, &g)
+		return zipErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return zipFile, nil
+}
+
+// Create zip from repository in a new temp dir, removed when zip is closed
+func (h GitHandler) zipRepo(src *Source, gitUtils *GitUtils) (ZipReadCloser, error) {
 	tmpDir, err := ioutil.TempDir("", "git-zipper")
 	if err != nil {
 		return nil, err
 	}
-	gitUtils := h.makeGitUtils(tmpDir, path)
-	err = gitUtils.Clone()
+	gitUtils.Folder = tmpDir
+	// submodules, lfs files and symlinks to follow need files on disk
+	if !gitUtils.Submodules && !gitUtils.Lfs && CtxSymlinkMode(src) != SymlinkFollow {
+		return h.zipCommit(src, gitUtils, tmpDir)
+	}
+	if gitUtils.CacheDir != "" && !gitUtils.refNameIsHash() {
+		// files and commit time must come from the same commit even if remote is updated meanwhile
+		commit, unlock, err := gitUtils.cacheCommit(src.Context())
+		if err != nil {
+			os.RemoveAll(tmpDir)
+			return nil, err
+		}
+		unlock()
+		gitUtils.RefName = commit.Hash.String()
+	}
+	err = gitUtils.CloneContext(src.Context())
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, err
+	}
+	commit, unlock, err := gitUtils.checkedOutCommit(src.Context())
 	if err != nil {
+		os.RemoveAll(tmpDir)
 		return nil, err
 	}
+	// files of checkout must be the ones git archive would give
+	err = applyExportAttributes(src.Context(), commit, tmpDir)
+	unlock()
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, err
+	}
+	if CtxReproducible(src) && !HasCtxSourceDate(src) {
+		SetCtxSourceDate(src, commit.Committer.When)
+	}
 	err = os.RemoveAll(filepath.Join(tmpDir, ".git"))
 	if err != nil {
+		os.RemoveAll(tmpDir)
 		return nil, err
 	}
-	newSrc := NewSource(tmpDir + gitUtils.SubPath)
-	newSrc.WithContext(src.Context())
+	newSrc := NewSource(tmpDir + gitUtils.SubPath).WithContext(src.Context())
+	// checkout only contains tracked files, they must be kept even if they match .gitignore patterns
+	SetCtxGitIgnore(newSrc, false)
 	lh := &LocalHandler{}
 	localFh, err := lh.Zip(newSrc)
 	if err != nil {
+		os.RemoveAll(tmpDir)
 		return nil, err
 	}
 	cleanFunc := func() error {
-		err := localFh.Close()
-		if err != nil {
-			return err
-		}
 		return os.RemoveAll(tmpDir)
 	}
-	return NewZipFile(localFh, localFh.Size(), cleanFunc), nil
+	zipFile := NewZipFile(localFh, localFh.Size(), cleanFunc)
+	zipFile.format = archiveFormat(localFh)
+	return zipFile, nil
 }
 
-func (h GitHandler) makeGitUtils(tmpDir, path string) *GitUtils {
+// Create zip directly from git objects of commit without writing its files on disk
+func (h GitHandler) zipCommit(src *Source, gitUtils *GitUtils, tmpDir string) (ZipReadCloser, error) {
+	commit, unlock, err := gitUtils.commitObject(src.Context())
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, err
+	}
+	// lock on cache is released as soon as every objects are read: when zip is written in temp file,
+	// or at the end of the pipe when it is streamed, close only releases it if writing didn't start
+	var unlockOnce sync.Once
+	release := func() {
+		unlockOnce.Do(func() {
+			unlock()
+		})
+	}
+	cleanFunc := func() error {
+		release()
+		return os.RemoveAll(tmpDir)
+	}
+	if CtxReproducible(src) && !HasCtxSourceDate(src) {
+		SetCtxSourceDate(src, commit.Committer.When)
+	}
+	zipFile, err := makeArchiveFile(src, "git-zipper", func(w EntryWriter) error {
+		defer release()
+		return writeCommitZip(src, commit, gitUtils.SubPath, w)
+	}, cleanFunc)
+	if err != nil {
+		cleanFunc()
+		return nil, err
+	}
+	return zipFile, nil
+}
+
+func (h GitHandler) makeGitUtils(tmpDir, path string, sshOptions *GitSshOptions) (*GitUtils, error) {
 	u, err := giturls.Parse(path)
 	if err != nil {
 		u, _ = giturls.Parse("ssh://" + path)
@@ -88,17 +196,23 @@ func (h GitHandler) makeGitUtils(tmpDir, path string) *GitUtils {
 		}
 	}
 
-	refName := "master"
+	refName := string(plumbing.HEAD)
 	if u.Fragment != "" {
 		refName = u.Fragment
 		u.Fragment = ""
 	}
-	authMethod, _ := createGitAuthMethod(u)
+	authMethod, err := createGitAuthMethod(u, sshOptions)
+	if err != nil {
+		return nil, err
+	}
+	submodules := queryBool(u.Query(), "submodules")
+	lfs := queryBool(u.Query(), "lfs")
 	if u.RawQuery != "" {
 		u.RawQuery = ""
 	}
 	finalUrl := u.String()
-	if u.Scheme == "ssh" && scpSyntax.MatchString(strings.TrimPrefix(u.String(), "ssh://")) {
+	// an url with a port (e.g.: ssh://git@host:2222/repo.git) can't be written in scp syntax
+	if u.Scheme == "ssh" && u.Port() == "" && scpSyntax.MatchString(strings.TrimPrefix(u.String(), "ssh://")) {
 		u.Scheme = ""
 		finalUrl = strings.TrimPrefix(u.String(), "//")
 	}
@@ -108,8 +222,12 @@ func (h GitHandler) makeGitUtils(tmpDir, path string) *GitUtils {
 		RefName:    refName,
 		AuthMethod: authMethod,
 		SubPath:    subPath,
+		Submodules: submodules,
+		Lfs:        lfs,
+		HttpClient: h.client,
+		SshOptions: sshOptions,
 	}
-	return gitUtils
+	return gitUtils, nil
 }
 
 func (h GitHandler) Sha1(src *Source) (string, error) {
@@ -120,8 +238,22 @@ func (h GitHandler) Sha1(src *Source) (string, error) {
 		return "", err
 	}
 	defer os.RemoveAll(tmpDir)
-	gitUtils := h.makeGitUtils(tmpDir, path)
-	return gitUtils.CommitSha1()
+	gitUtils, err := h.makeGitUtils(tmpDir, path, CtxGitSshOptions(src))
+	if err != nil {
+		return "", err
+	}
+	h.setCredentials(src, gitUtils)
+	gitUtils.CacheDir = CtxGitCacheDir(src)
+	return gitUtils.CommitSha1Context(src.Context())
+}
+
+// Same as Sha1, signature is already the commit sha1
+func (h GitHandler) MetadataSha1(src *Source) (string, error) {
+	return h.Sha1(src)
+}
+
+func (h GitHandler) SignatureStrategies() []string {
+	return []string{SignatureFast, SignatureFull, SignatureMetadata}
 }
 
 func (h GitHandler) Detect(src *Source) bool {
@@ -136,6 +268,11 @@ func (h GitHandler) Detect(src *Source) bool {
 	return HasExtFile(u.Path, ".git")
 }
 
+// Set credentials providers of source on git utils, they are asked when remote requires authentication
+func (h GitHandler) setCredentials(src *Source, gitUtils *GitUtils) {
+	gitUtils.Credentials = sourceCredentialProvider{src}
+}
+
 func (h *GitHandler) setHttpClient(src *Source) {
 	*h.client = *CtxHttpClient(src)
 }
@@ -150,20 +287,29 @@ type GitUtils struct {
 	RefName    string
 	AuthMethod transport.AuthMethod
 	SubPath    string
+	// Directory where remotes are kept as bare mirrors between calls, repository is cloned in Folder when empty
+	// otherwise mirror is updated by fetching only new objects and files are written from it in Folder
+	CacheDir string
+	// Clone submodules at their pinned commit, recursively
+	Submodules bool
+	// Replace git lfs pointer files by their content
+	Lfs bool
+	// Http client used to reach git lfs server, http.DefaultClient is used when nil
+	HttpClient *http.Client
+	// Provider of credentials for http urls without credentials inside them (e.g.: for submodules),
+	// they are only asked when auth method is not set and remote requires authentication
+	Credentials CredentialProvider
+	// Options for ssh urls: host keys verification and private key (see GitSshOptions)
+	SshOptions *GitSshOptions
 }
 
 var refTypes []string = []string{"heads", "tags"}
 
-func createGitAuthMethod(uri *url.URL) (transport.AuthMethod, error) {
-	privKeyPath := uri.Query().Get("private-key")
-	passKey := uri.Query().Get("password-key")
-
-	if uri.Scheme == "ssh" && privKeyPath == "" {
-		return ssh.NewSSHAgentAuth(uri.User.Username())
-	}
-
-	if uri.Scheme == "ssh" && privKeyPath != "" {
-		return ssh.NewPublicKeysFromFile(uri.User.Username(), privKeyPath, passKey)
+// Create auth method for an url, for ssh urls the key given by private-key query parameter is used first,
+// then private key from ssh options and ssh agent otherwise, host keys are verified as asked by ssh options
+func createGitAuthMethod(uri *url.URL, sshOptions *GitSshOptions) (transport.AuthMethod, error) {
+	if uri.Scheme == "ssh" {
+		return sshAuthMethod(uri, sshOptions)
 	}
 	if uri.User == nil || uri.User.Username() == "" {
 		return nil, nil
@@ -175,83 +321,511 @@ func createGitAuthMethod(uri *url.URL) (transport.AuthMethod, error) {
 	}, nil
 }
 
+// Retrieve auth method for an http url from credentials provider, nil is returned for other urls or when there is no credentials
+func (g GitUtils) providedAuth(ctx context.Context, rawUrl string) (transport.AuthMethod, error) {
+	if g.Credentials == nil || !IsWebURL(rawUrl) {
+		return nil, nil
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := g.Credentials.Credentials(ctx, u)
+	if err != nil || creds == nil {
+		return nil, err
+	}
+	return &githttp.BasicAuth{
+		Username: creds.Username,
+		Password: creds.Password,
+	}, nil
+}
+
+// Run fn with g, when remote requires authentication and no auth method is set,
+// fn is run again with an auth method made from credentials providers for url
+func (g GitUtils) withProvidedAuth(ctx context.Context, fn func(g GitUtils) error) error {
+	err := fn(g)
+	if g.AuthMethod != nil || !errors.Is(err, transport.ErrAuthenticationRequired) {
+		return err
+	}
+	authMethod, authErr := g.providedAuth(ctx, g.Url)
+	if authErr != nil {
+		return authErr
+	}
+	if authMethod == nil {
+		return err
+	}
+	g.AuthMethod = authMethod
+	return fn(g)
+}
+
 func (g GitUtils) Clone() error {
-	_, err := g.findRepo(false)
+	return g.CloneContext(context.Background())
+}
+
+// Clone repository, cloning is aborted when context is cancelled
+// when a sub path is set, only its files are written in folder
+func (g GitUtils) CloneContext(ctx context.Context) error {
+	return g.withProvidedAuth(ctx, func(g GitUtils) error {
+		return g.clone(ctx)
+	})
+}
+
+func (g GitUtils) clone(ctx context.Context) error {
+	if g.CacheDir != "" {
+		return g.checkoutFromCache(ctx)
+	}
+	if g.SubPath != "" {
+		return g.sparseCheckout(ctx)
+	}
+	repo, err := g.findRepo(ctx, false)
+	if err != nil {
+		return err
+	}
+	if !g.Submodules && !g.Lfs {
+		return nil
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	return g.completeCheckout(ctx, commit)
+}
+
+// Retrieve commit for ref name, from cache when using one, otherwise repository is cloned as a bare one in .git of folder
+// commit can be used until returned unlock function is called
+func (g GitUtils) commitObject(ctx context.Context) (*object.Commit, func() error, error) {
+	if g.CacheDir != "" {
+		return g.cacheCommit(ctx)
+	}
+	bareUtils := g
+	bareUtils.Folder = filepath.Join(g.Folder, ".git")
+	repo, err := bareUtils.findRepo(ctx, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, nil, err
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, nil, err
+	}
+	return commit, func() error { return nil }, nil
+}
+
+// Clone repository as a bare one in .git of folder and write from it only files of sub path,
+// others files of commit are never written on disk
+func (g GitUtils) sparseCheckout(ctx context.Context) error {
+	commit, _, err := g.commitObject(ctx)
+	if err != nil {
+		return err
+	}
+	err = writeCommitFiles(ctx, commit, g.Folder, g.SubPath)
 	if err != nil {
 		return err
 	}
-	return nil
+	return g.completeCheckout(ctx, commit)
 }
+
+// Check if a path from repository root is inside sub path, every paths are inside when no sub path is set
+func (g GitUtils) inSubPath(p string) bool {
+	prefix := strings.Trim(g.SubPath, "/")
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
 func (g GitUtils) CommitSha1() (string, error) {
+	return g.CommitSha1Context(context.Background())
+}
+
+// Retrieve commit sha1, retrieving is aborted when context is cancelled
+func (g GitUtils) CommitSha1Context(ctx context.Context) (string, error) {
+	var sha1 string
+	err := g.withProvidedAuth(ctx, func(g GitUtils) error {
+		var err error
+		sha1, err = g.commitSha1(ctx)
+		return err
+	})
+	return sha1, err
+}
+
+func (g GitUtils) commitSha1(ctx context.Context) (string, error) {
 	if g.refNameIsHash() {
 		return g.RefName, nil
 	}
-	repo, err := g.findRepo(true)
+	refs, err := g.lsRemote(ctx)
 	if err != nil {
 		return "", err
 	}
-	iter, err := repo.Log(&git.LogOptions{})
+	_, hash, err := g.findAdvertisedRef(refs)
+	if err == plumbing.ErrReferenceNotFound {
+		// abbreviated commit can only be found in history
+		var repo *git.Repository
+		repo, err = g.findRepoFromHash(ctx, true)
+		if err != nil {
+			return "", err
+		}
+		var head *plumbing.Reference
+		head, err = repo.Head()
+		if err == nil {
+			hash = head.Hash()
+		}
+	}
 	if err != nil {
 		return "", err
 	}
-	defer iter.Close()
-	commit, err := iter.Next()
+	return hash.String(), nil
+}
+
+// Retrieve references advertised by remote, as git ls-remote does, without cloning repository
+// retrieving is aborted when context is cancelled
+func (g GitUtils) lsRemote(ctx context.Context) (*packp.AdvRefs, error) {
+	session, refs, err := g.openUploadPack(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return commit.Hash.String(), nil
+	session.Close()
+	return refs, nil
 }
+
+// Open an upload-pack session on remote and retrieve references it advertises, session must be closed by caller
+// retrieving is aborted when context is cancelled
+func (g GitUtils) openUploadPack(ctx context.Context) (transport.UploadPackSession, *packp.AdvRefs, error) {
+	endpoint, err := transport.NewEndpoint(g.Url)
+	if err != nil {
+		return nil, nil, err
+	}
+	cli, err := client.NewClient(endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := cli.NewUploadPackSession(endpoint, g.AuthMethod)
+	if err != nil {
+		return nil, nil, err
+	}
+	type result struct {
+		refs *packp.AdvRefs
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		refs, err := session.AdvertisedReferences()
+		done <- result{refs, err}
+	}()
+	select {
+	case <-ctx.Done():
+		session.Close()
+		return nil, nil, ctx.Err()
+	case r := <-done:
+		if r.err != nil {
+			session.Close()
+			return nil, nil, r.err
+		}
+		return session, r.refs, nil
+	}
+}
+
+// Find full name of ref name and sha1 of its commit in references advertised by remote, annotated tags are peeled to their commit
+// ref name can be HEAD (resolved to remote default branch), a branch, a tag or a full reference name (e.g.: refs/pull/42/head)
+func (g GitUtils) findAdvertisedRef(refs *packp.AdvRefs) (plumbing.ReferenceName, plumbing.Hash, error) {
+	if g.RefName == string(plumbing.HEAD) {
+		return g.findAdvertisedHead(refs)
+	}
+	names := []string{g.RefName}
+	if !strings.HasPrefix(g.RefName, "refs/") {
+		names = make([]string, 0)
+		for _, refType := range refTypes {
+			names = append(names, fmt.Sprintf("refs/%s/%s", refType, g.RefName))
+		}
+	}
+	for _, name := range names {
+		if hash, ok := refs.Peeled[name]; ok {
+			return plumbing.ReferenceName(name), hash, nil
+		}
+		if hash, ok := refs.References[name]; ok {
+			return plumbing.ReferenceName(name), hash, nil
+		}
+	}
+	if g.refNameIsShortHash() {
+		return g.findAdvertisedShortHash(refs)
+	}
+	return "", plumbing.ZeroHash, fmt.Errorf("couldn't find remote ref %s", g.RefName)
+}
+
+// Find a reference pointing to the commit abbreviated by ref name, annotated tags are peeled to their commit
+// plumbing.ErrReferenceNotFound is returned when abbreviated commit is not at the tip of an advertised reference
+func (g GitUtils) findAdvertisedShortHash(refs *packp.AdvRefs) (plumbing.ReferenceName, plumbing.Hash, error) {
+	prefix := strings.ToLower(g.RefName)
+	names := make([]string, 0)
+	for name := range refs.References {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var foundName plumbing.ReferenceName
+	var foundHash plumbing.Hash
+	for _, name := range names {
+		hash, ok := refs.Peeled[name]
+		if !ok {
+			hash = refs.References[name]
+		}
+		if !strings.HasPrefix(hash.String(), prefix) {
+			continue
+		}
+		if foundName != "" && hash != foundHash {
+			return "", plumbing.ZeroHash, fmt.Errorf("short sha1 %s is ambiguous", g.RefName)
+		}
+		if foundName == "" {
+			foundName, foundHash = plumbing.ReferenceName(name), hash
+		}
+	}
+	if foundName == "" {
+		return "", plumbing.ZeroHash, plumbing.ErrReferenceNotFound
+	}
+	return foundName, foundHash, nil
+}
+
+// Find branch targeted by remote HEAD, HEAD is kept when remote doesn't advertise it (e.g.: on a detached HEAD)
+func (g GitUtils) findAdvertisedHead(refs *packp.AdvRefs) (plumbing.ReferenceName, plumbing.Hash, error) {
+	if refs.Head == nil {
+		return "", plumbing.ZeroHash, fmt.Errorf("remote repository has no HEAD")
+	}
+	allRefs, err := refs.AllReferences()
+	if err != nil {
+		return "", plumbing.ZeroHash, err
+	}
+	head, err := allRefs.Reference(plumbing.HEAD)
+	if err == nil && head.Type() == plumbing.SymbolicReference {
+		return head.Target(), *refs.Head, nil
+	}
+	return plumbing.HEAD, *refs.Head, nil
+}
+
+// Retrieve committer time of the commit checked out in folder (or of ref name from cache when using one)
+func (g GitUtils) CommitTime() (time.Time, error) {
+	commit, unlock, err := g.checkedOutCommit(context.Background())
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer unlock()
+	return commit.Committer.When, nil
+}
+
+// Retrieve commit checked out in folder (or commit of ref name from cache when using one)
+// commit can be used until returned unlock function is called
+func (g GitUtils) checkedOutCommit(ctx context.Context) (*object.Commit, func() error, error) {
+	if g.CacheDir != "" {
+		return g.cacheCommit(ctx)
+	}
+	repo, err := git.PlainOpen(g.Folder)
+	if err != nil {
+		return nil, nil, err
+	}
+	ref, err := repo.Head()
+	if err != nil {
+		return nil, nil, err
+	}
+	commit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, nil, err
+	}
+	return commit, func() error { return nil }, nil
+}
+
 func (g GitUtils) refNameIsHash() bool {
-	return len(g.RefName) == 40
+	return len(g.RefName) == 40 && hashRegex.MatchString(g.RefName)
+}
+
+// Check if ref name could be an abbreviated commit sha1, refs with this name are used first if they exist
+func (g GitUtils) refNameIsShortHash() bool {
+	return len(g.RefName) >= 4 && len(g.RefName) < 40 && hashRegex.MatchString(g.RefName)
 }
-func (g GitUtils) findRepoFromHash(isBare bool) (*git.Repository, error) {
-	repo, err := git.PlainClone(g.Folder, isBare, &git.CloneOptions{
+
+// Clone repository at commit given by ref name, as a full or abbreviated sha1
+// only the commit is fetched when it's a full sha1 and remote allows it, otherwise entire history is cloned
+func (g GitUtils) findRepoFromHash(ctx context.Context, isBare bool) (*git.Repository, error) {
+	if g.refNameIsHash() {
+		repo, err := g.findRepoFromShallowHash(ctx, isBare, plumbing.NewHash(g.RefName))
+		if err != errShallowHashNotAllowed {
+			return repo, err
+		}
+	}
+	repo, err := git.PlainCloneContext(ctx, g.Folder, isBare, &git.CloneOptions{
 		URL:  g.Url,
 		Auth: g.AuthMethod,
 	})
 	if err != nil {
 		return nil, err
 	}
-	tree, err := repo.Worktree()
+	hash, err := resolveShortHash(repo, g.RefName)
 	if err != nil {
 		return nil, err
 	}
-	err = tree.Checkout(&git.CheckoutOptions{
-		Hash:  plumbing.NewHash(g.RefName),
-		Force: true,
-	})
+	return repo, checkoutHash(repo, isBare, hash)
+}
+
+var errShallowHashNotAllowed = fmt.Errorf("remote doesn't allow to fetch a single commit")
+
+// Fetch only a commit, without its history, remote must allow it with uploadpack.allowReachableSHA1InWant
+// errShallowHashNotAllowed is returned when remote doesn't allow it
+func (g GitUtils) findRepoFromShallowHash(ctx context.Context, isBare bool, hash plumbing.Hash) (*git.Repository, error) {
+	session, refs, err := g.openUploadPack(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	if !refs.Capabilities.Supports(capability.AllowReachableSHA1InWant) {
+		return nil, errShallowHashNotAllowed
+	}
+	req := packp.NewUploadPackRequestFromCapabilities(refs.Capabilities)
+	req.Wants = []plumbing.Hash{hash}
+	req.Depth = packp.DepthCommits(1)
+	err = req.Capabilities.Set(capability.Shallow)
+	if err != nil {
+		return nil, err
+	}
+	if refs.Capabilities.Supports(capability.NoProgress) {
+		err = req.Capabilities.Set(capability.NoProgress)
+		if err != nil {
+			return nil, err
+		}
+	}
+	repo, err := git.PlainInit(g.Folder, isBare)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := session.UploadPack(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+	err = repo.Storer.SetShallow(resp.Shallows)
+	if err != nil {
+		return nil, err
+	}
+	var packReader io.Reader = resp
+	switch {
+	case req.Capabilities.Supports(capability.Sideband64k):
+		packReader = sideband.NewDemuxer(sideband.Sideband64k, resp)
+	case req.Capabilities.Supports(capability.Sideband):
+		packReader = sideband.NewDemuxer(sideband.Sideband, resp)
+	}
+	err = packfile.UpdateObjectStorage(repo.Storer, packReader)
 	if err != nil {
 		return nil, err
 	}
-	return repo, nil
+	return repo, checkoutHash(repo, isBare, hash)
 }
-func (g GitUtils) findRepo(isBare bool) (*git.Repository, error) {
-	if g.refNameIsHash() {
-		return g.findRepoFromHash(isBare)
-	}
-	var repo *git.Repository
-	var err error
-	for _, refType := range refTypes {
-		repo, err = git.PlainClone(g.Folder, isBare, &git.CloneOptions{
-			URL:          g.Url,
-			SingleBranch: true,
-			Auth:         g.AuthMethod,
-			ReferenceName: plumbing.ReferenceName(fmt.Sprintf(
-				"refs/%s/%s",
-				refType,
-				strings.ToLower(g.RefName),
-			)),
-			Depth: 1,
-		})
-		if err == nil {
-			return repo, nil
+
+// Find commit abbreviated by prefix in repository history
+func resolveShortHash(repo *git.Repository, prefix string) (plumbing.Hash, error) {
+	if len(prefix) == 40 {
+		return plumbing.NewHash(prefix), nil
+	}
+	prefix = strings.ToLower(prefix)
+	iter, err := repo.CommitObjects()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	defer iter.Close()
+	found := plumbing.ZeroHash
+	err = iter.ForEach(func(commit *object.Commit) error {
+		if !strings.HasPrefix(commit.Hash.String(), prefix) {
+			return nil
 		}
-		if strings.Contains(err.Error(), "couldn't find remote ref") {
-			os.RemoveAll(g.Folder)
-			os.Mkdir(g.Folder, 0777)
-			continue
+		if !found.IsZero() {
+			return fmt.Errorf("short sha1 %s is ambiguous", prefix)
 		}
+		found = commit.Hash
+		return nil
+	})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if found.IsZero() {
+		return plumbing.ZeroHash, plumbing.ErrObjectNotFound
+	}
+	return found, nil
+}
+
+// Checkout commit in worktree, or only set HEAD on commit for a bare repository
+func checkoutHash(repo *git.Repository, isBare bool, hash plumbing.Hash) error {
+	if isBare {
+		return repo.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, hash))
+	}
+	tree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	return tree.Checkout(&git.CheckoutOptions{
+		Hash:  hash,
+		Force: true,
+	})
+}
+
+func (g GitUtils) findRepo(ctx context.Context, isBare bool) (*git.Repository, error) {
+	if g.refNameIsHash() {
+		return g.findRepoFromHash(ctx, isBare)
+	}
+	refs, err := g.lsRemote(ctx)
+	if err != nil {
+		return nil, err
+	}
+	name, hash, err := g.findAdvertisedRef(refs)
+	if err == plumbing.ErrReferenceNotFound {
+		return g.findRepoFromHash(ctx, isBare)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if name != plumbing.HEAD && !name.IsBranch() && !name.IsTag() {
+		return g.findRepoFromRef(ctx, isBare, name, hash)
+	}
+	return git.PlainCloneContext(ctx, g.Folder, isBare, &git.CloneOptions{
+		URL:           g.Url,
+		SingleBranch:  true,
+		Auth:          g.AuthMethod,
+		ReferenceName: name,
+		Depth:         1,
+	})
+}
+
+// Fetch only a reference which is neither a branch nor a tag (e.g.: refs/pull/42/head) and checkout its commit
+func (g GitUtils) findRepoFromRef(ctx context.Context, isBare bool, name plumbing.ReferenceName, hash plumbing.Hash) (*git.Repository, error) {
+	repo, err := git.PlainInit(g.Folder, isBare)
+	if err != nil {
+		return nil, err
+	}
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{g.Url},
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = repo.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", name, name))},
+		Auth:     g.AuthMethod,
+		Depth:    1,
+	})
+	if err != nil {
 		return nil, err
 	}
-	return repo, err
+	return repo, checkoutHash(repo, isBare, hash)
+}
+
+// check if a query parameter is set to true, a parameter without value (e.g.: ?lfs) is considered as true
+func queryBool(query url.Values, key string) bool {
+	values, ok := query[key]
+	if !ok {
+		return false
+	}
+	if values[0] == "" {
+		return true
+	}
+	b, _ := strconv.ParseBool(values[0])
+	return b
 }
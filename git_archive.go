@@ -0,0 +1,345 @@
+package zipper
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ArthurHlt/zipper/dirfiles"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// Write files of commit, or only the ones of sub path, in an archive as git archive does, without a working tree:
+//   - files are read from git objects, executable bit and symlinks come from modes of tree entries
+//   - modification time of every entries is commit time
+//   - ignore files (.zipignore, .cfignore, ...) are read from the tree itself
+//   - paths with export-ignore attribute in .gitattributes files are removed
+//     and placeholders in files with export-subst attribute are expanded (see expandExportSubst)
+//   - submodules are written as empty folders
+func writeCommitZip(src *Source, commit *object.Commit, subPath string, w EntryWriter) error {
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	attributes, err := loadGitAttributes(tree)
+	if err != nil {
+		return err
+	}
+	prefix := strings.Trim(subPath, "/")
+	if prefix != "" {
+		tree, err = subTree(tree, prefix)
+		if err != nil {
+			return err
+		}
+	}
+	ignore, err := dirfiles.DirFiles{}.IgnoreFilesFS(treeFS{tree})
+	if err != nil {
+		return err
+	}
+	archiver := &treeArchiver{
+		ctx:        src.Context(),
+		writer:     w,
+		ignore:     ignore,
+		filter:     CtxPathFilter(src),
+		attributes: attributes,
+		symlinks:   CtxSymlinkMode(src),
+		prefix:     prefix,
+		commit:     commit,
+		pending:    make(map[string]*zip.FileHeader),
+	}
+	return archiver.writeTree(tree, "")
+}
+
+type treeArchiver struct {
+	ctx        context.Context
+	writer     EntryWriter
+	ignore     dirfiles.DirIgnoreFiles
+	filter     *dirfiles.PathFilter
+	attributes *gitAttributes
+	symlinks   SymlinkMode
+	// path of written tree from repository root, attributes are matched on paths from root
+	prefix string
+	commit *object.Commit
+	// directories not included by filter, they are written only before an included entry inside them
+	pending map[string]*zip.FileHeader
+}
+
+// write entries of tree, dir is the slash separated path of tree relative to written tree
+func (a *treeArchiver) writeTree(tree *object.Tree, dir string) error {
+	for _, entry := range tree.Entries {
+		if a.ctx.Err() != nil {
+			return a.ctx.Err()
+		}
+		name := path.Join(dir, entry.Name)
+		isDir := entry.Mode == filemode.Dir || entry.Mode == filemode.Submodule
+		if a.isIgnored(name, isDir) {
+			continue
+		}
+		if entry.Mode == filemode.Symlink && a.symlinks == SymlinkSkip {
+			continue
+		}
+		header := &zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: a.commit.Committer.When,
+		}
+		switch entry.Mode {
+		case filemode.Dir, filemode.Submodule:
+			header.Name += "/"
+			header.Method = zip.Store
+			header.SetMode(os.ModeDir | 0755)
+		case filemode.Symlink:
+			header.Method = zip.Store
+			header.SetMode(os.ModeSymlink | 0777)
+		case filemode.Executable:
+			header.SetMode(0755)
+		default:
+			header.SetMode(0644)
+		}
+		if !a.filter.Included(name, isDir) {
+			if isDir {
+				a.pending[name] = header
+			}
+		} else {
+			err := a.writeEntry(tree, entry, header)
+			if err != nil {
+				return err
+			}
+		}
+		if entry.Mode != filemode.Dir {
+			continue
+		}
+		subTree, err := tree.Tree(entry.Name)
+		if err != nil {
+			return err
+		}
+		err = a.writeTree(subTree, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *treeArchiver) isIgnored(name string, isDir bool) bool {
+	if a.filter.Excluded(name, isDir) || a.ignore.PathShouldBeIgnored(name, isDir) {
+		return true
+	}
+	return a.attributes.isSet(path.Join(a.prefix, name), "export-ignore")
+}
+
+func (a *treeArchiver) writeEntry(tree *object.Tree, entry object.TreeEntry, header *zip.FileHeader) error {
+	name := strings.TrimSuffix(header.Name, "/")
+	err := writePendingDirs(a.writer, a.pending, name)
+	if err != nil {
+		return err
+	}
+	if entry.Mode == filemode.Dir || entry.Mode == filemode.Submodule {
+		_, err = a.writer.CreateHeader(header)
+		return err
+	}
+	file, err := tree.TreeEntryFile(&entry)
+	if err != nil {
+		return err
+	}
+	if entry.Mode == filemode.Symlink {
+		target, err := file.Contents()
+		if err != nil {
+			return err
+		}
+		if !dirfiles.IsRelativeLinkInside(name, target) {
+			return fmt.Errorf("Symlink %s targets %s which is outside of repository", name, target)
+		}
+	}
+	if entry.Mode != filemode.Symlink && a.attributes.isSet(path.Join(a.prefix, name), "export-subst") {
+		content, err := file.Contents()
+		if err != nil {
+			return err
+		}
+		expanded := expandExportSubst([]byte(content), a.commit)
+		header.UncompressedSize64 = uint64(len(expanded))
+		fw, err := a.writer.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(expanded)
+		return err
+	}
+	header.UncompressedSize64 = uint64(file.Size)
+	fw, err := a.writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	r, err := file.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(fw, NewContextReader(a.ctx, r))
+	return err
+}
+
+// Read only file system over a git tree, it lets ignore files be read from the tree without writing it on disk
+type treeFS struct {
+	tree *object.Tree
+}
+
+func (t treeFS) Open(name string) (fs.File, error) {
+	info, err := t.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &treeFSFile{info: info}, nil
+	}
+	file, err := t.tree.File(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	r, err := file.Reader()
+	if err != nil {
+		return nil, err
+	}
+	return &treeFSFile{info: info, ReadCloser: r}, nil
+}
+
+func (t treeFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return treeFSInfo{name: ".", mode: filemode.Dir}, nil
+	}
+	entry, err := t.tree.FindEntry(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return t.entryInfo(path.Dir(name), *entry)
+}
+
+func (t treeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	tree := t.tree
+	if name != "." {
+		var err error
+		tree, err = t.tree.Tree(name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+		}
+	}
+	entries := make([]fs.DirEntry, 0, len(tree.Entries))
+	for _, entry := range tree.Entries {
+		entries = append(entries, treeFSDirEntry{
+			treeFSInfo: treeFSInfo{name: entry.Name, mode: entry.Mode},
+			fsys:       t,
+			dir:        name,
+		})
+	}
+	return entries, nil
+}
+
+func (t treeFS) ReadFile(name string) ([]byte, error) {
+	file, err := t.tree.File(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	content, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(content), nil
+}
+
+// size of files is read from their blob, dir is the path of the folder containing entry
+func (t treeFS) entryInfo(dir string, entry object.TreeEntry) (treeFSInfo, error) {
+	info := treeFSInfo{name: entry.Name, mode: entry.Mode}
+	if !entry.Mode.IsFile() {
+		return info, nil
+	}
+	file, err := t.tree.File(path.Join(dir, entry.Name))
+	if err != nil {
+		return info, err
+	}
+	info.size = file.Size
+	return info, nil
+}
+
+type treeFSFile struct {
+	io.ReadCloser
+	info fs.FileInfo
+}
+
+func (f *treeFSFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *treeFSFile) Read(p []byte) (int, error) {
+	if f.ReadCloser == nil {
+		return 0, &fs.PathError{Op: "read", Path: f.info.Name(), Err: fs.ErrInvalid}
+	}
+	return f.ReadCloser.Read(p)
+}
+
+func (f *treeFSFile) Close() error {
+	if f.ReadCloser == nil {
+		return nil
+	}
+	return f.ReadCloser.Close()
+}
+
+// File info of a tree entry, it's also used as a directory entry
+type treeFSInfo struct {
+	name string
+	mode filemode.FileMode
+	size int64
+}
+
+func (i treeFSInfo) Name() string {
+	return i.name
+}
+
+func (i treeFSInfo) Size() int64 {
+	return i.size
+}
+
+// submodules are not folders of the tree, their content is in another repository
+func (i treeFSInfo) Mode() fs.FileMode {
+	if i.mode == filemode.Submodule {
+		return fs.ModeIrregular
+	}
+	mode, _ := i.mode.ToOSFileMode()
+	return mode
+}
+
+func (i treeFSInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (i treeFSInfo) IsDir() bool {
+	return i.mode == filemode.Dir
+}
+
+func (i treeFSInfo) Sys() interface{} {
+	return nil
+}
+
+// Directory entry of a tree, size of a file is only read from its blob when info is asked
+type treeFSDirEntry struct {
+	treeFSInfo
+	fsys treeFS
+	dir  string
+}
+
+func (e treeFSDirEntry) Type() fs.FileMode {
+	return e.Mode().Type()
+}
+
+func (e treeFSDirEntry) Info() (fs.FileInfo, error) {
+	return e.fsys.entryInfo(e.dir, object.TreeEntry{Name: e.name, Mode: e.mode})
+}
@@ -0,0 +1,183 @@
+package zipper
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/gitattributes"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+const gitAttributesFile = ".gitattributes"
+
+// Attributes read from every .gitattributes files of a tree (see https://git-scm.com/docs/gitattributes)
+type gitAttributes struct {
+	// lines in order of increasing priority: root file first, then files down the path
+	stack  []gitattributes.MatchAttribute
+	macros map[string]gitattributes.MatchAttribute
+}
+
+// Read attributes from .gitattributes files of tree and of its folders, macros can only be defined at root
+func loadGitAttributes(tree *object.Tree) (*gitAttributes, error) {
+	attrs := &gitAttributes{
+		macros: make(map[string]gitattributes.MatchAttribute),
+	}
+	err := attrs.load(tree, nil)
+	if err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+func (a *gitAttributes) load(tree *object.Tree, domain []string) error {
+	if entry, err := tree.FindEntry(gitAttributesFile); err == nil && entry.Mode.IsFile() && entry.Mode != filemode.Symlink {
+		file, err := tree.TreeEntryFile(entry)
+		if err != nil {
+			return err
+		}
+		content, err := file.Contents()
+		if err != nil {
+			return err
+		}
+		a.parse(content, domain)
+	}
+	for _, entry := range tree.Entries {
+		if entry.Mode != filemode.Dir {
+			continue
+		}
+		subTree, err := tree.Tree(entry.Name)
+		if err != nil {
+			return err
+		}
+		err = a.load(subTree, append(append([]string{}, domain...), entry.Name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalid lines are skipped, as git does with a warning
+func (a *gitAttributes) parse(content string, domain []string) {
+	for _, line := range strings.Split(content, "\n") {
+		attr, err := gitattributes.ParseAttributesLine(line, domain, len(domain) == 0)
+		if err != nil || attr.Name == "" {
+			continue
+		}
+		if attr.Pattern == nil {
+			a.macros[attr.Name] = attr
+			continue
+		}
+		a.stack = append(a.stack, attr)
+	}
+}
+
+// Retrieve attribute name of path (slash separated from tree root), the line with the highest priority setting it wins
+// attributes set through a macro (e.g.: [attr]ignored export-ignore) are also found
+func (a *gitAttributes) get(p string, name string) (gitattributes.Attribute, bool) {
+	if a == nil {
+		return nil, false
+	}
+	splitPath := strings.Split(p, "/")
+	for i := len(a.stack) - 1; i >= 0; i-- {
+		if !a.stack[i].Pattern.Match(splitPath) {
+			continue
+		}
+		attrs := a.stack[i].Attributes
+		// later attributes on a line override former ones
+		for j := len(attrs) - 1; j >= 0; j-- {
+			if attrs[j].Name() == name {
+				return attrs[j], true
+			}
+			if macro, ok := a.macros[attrs[j].Name()]; ok && attrs[j].IsSet() {
+				for _, macroAttr := range macro.Attributes {
+					if macroAttr.Name() == name {
+						return macroAttr, true
+					}
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// Check if attribute name is set (e.g.: export-ignore) on path
+func (a *gitAttributes) isSet(p string, name string) bool {
+	attr, ok := a.get(p, name)
+	return ok && attr.IsSet()
+}
+
+// Apply export attributes of commit on its files checked out in dir, as git archive does:
+// paths with export-ignore attribute are removed and files with export-subst attribute have their placeholders expanded
+// files which are not checked out (e.g.: outside of a sub path) are skipped
+func applyExportAttributes(ctx context.Context, commit *object.Commit, dir string) error {
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	attributes, err := loadGitAttributes(tree)
+	if err != nil {
+		return err
+	}
+	if len(attributes.stack) == 0 {
+		return nil
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	return attributes.applyExport(ctx, commit, tree, realDir, "")
+}
+
+func (a *gitAttributes) applyExport(ctx context.Context, commit *object.Commit, tree *object.Tree, dir, treePath string) error {
+	for _, entry := range tree.Entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		name := path.Join(treePath, entry.Name)
+		target, err := extractPath(dir, name)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			continue
+		}
+		if a.isSet(name, "export-ignore") {
+			err = os.RemoveAll(target)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		switch {
+		case entry.Mode == filemode.Dir:
+			subTree, err := tree.Tree(entry.Name)
+			if err != nil {
+				return err
+			}
+			err = a.applyExport(ctx, commit, subTree, dir, name)
+			if err != nil {
+				return err
+			}
+		case entry.Mode.IsFile() && entry.Mode != filemode.Symlink && a.isSet(name, "export-subst"):
+			content, err := ioutil.ReadFile(target)
+			if err != nil {
+				return err
+			}
+			stat, err := os.Stat(target)
+			if err != nil {
+				return err
+			}
+			err = ioutil.WriteFile(target, expandExportSubst(content, commit), stat.Mode())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
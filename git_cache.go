@@ -0,0 +1,218 @@
+package zipper
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// refspecs used to keep branches and tags of a cached mirror identical to remote ones
+var mirrorRefSpecs = []config.RefSpec{
+	"+refs/heads/*:refs/heads/*",
+	"+refs/tags/*:refs/tags/*",
+}
+
+// Directory in cache dir containing bare mirror of remote, named after sha1 of normalised remote url
+func (g GitUtils) cachePath() string {
+	sum := sha1.Sum([]byte(normaliseGitUrl(g.Url)))
+	return filepath.Join(g.CacheDir, hex.EncodeToString(sum[:]))
+}
+
+// Normalise a remote url to use the same cache for different writings of an url,
+// credentials, case of scheme and host and trailing .git are removed and scp-like syntax is converted to ssh url
+func normaliseGitUrl(rawUrl string) string {
+	u, err := url.Parse(scpToSshUrl(rawUrl))
+	if err != nil {
+		return rawUrl
+	}
+	u.User = nil
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git")
+	return u.String()
+}
+
+// Open bare mirror of remote from cache, creating it when needed
+// mirror is locked for other processes until returned unlock function is called
+func (g GitUtils) openCache(ctx context.Context) (*git.Repository, func() error, error) {
+	err := os.MkdirAll(g.CacheDir, 0755)
+	if err != nil {
+		return nil, nil, err
+	}
+	path := g.cachePath()
+	unlock, err := lockFile(ctx, path+".lock")
+	if err != nil {
+		return nil, nil, err
+	}
+	repo, err := git.PlainOpen(path)
+	if err == git.ErrRepositoryNotExists {
+		repo, err = git.PlainInit(path, true)
+	}
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	return repo, unlock, nil
+}
+
+// Fetch in mirror objects and references which are not already in it,
+// a reference which is neither a branch nor a tag (e.g.: refs/pull/42/head) is fetched in addition when given
+// an anonymous remote is used to not store url, which can contain credentials, in mirror config
+func (g GitUtils) fetchCache(ctx context.Context, repo *git.Repository, name plumbing.ReferenceName) error {
+	remote, err := repo.CreateRemoteAnonymous(&config.RemoteConfig{
+		Name: "anonymous",
+		URLs: []string{g.Url},
+	})
+	if err != nil {
+		return err
+	}
+	refSpecs := mirrorRefSpecs
+	if name != "" && name != plumbing.HEAD && !name.IsBranch() && !name.IsTag() {
+		refSpecs = append([]config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", name, name))}, mirrorRefSpecs...)
+	}
+	err = remote.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: refSpecs,
+		Auth:     g.AuthMethod,
+		Tags:     git.AllTags,
+		Force:    true,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return err
+}
+
+// Retrieve commit for ref name from cache, ref name is resolved with references advertised by remote
+// and mirror is only updated when it doesn't contain the commit yet
+// mirror is locked for other processes until returned unlock function is called
+func (g GitUtils) cacheCommit(ctx context.Context) (*object.Commit, func() error, error) {
+	var name plumbing.ReferenceName
+	hashPrefix := g.RefName
+	if !g.refNameIsHash() {
+		refs, err := g.lsRemote(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		var hash plumbing.Hash
+		name, hash, err = g.findAdvertisedRef(refs)
+		if err == nil {
+			hashPrefix = hash.String()
+		} else if err != plumbing.ErrReferenceNotFound {
+			return nil, nil, err
+		}
+	}
+	repo, unlock, err := g.openCache(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	commit, err := cachedCommit(repo, hashPrefix)
+	if err == plumbing.ErrObjectNotFound {
+		err = g.fetchCache(ctx, repo, name)
+		if err == nil {
+			commit, err = cachedCommit(repo, hashPrefix)
+		}
+	}
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	return commit, unlock, nil
+}
+
+// Retrieve commit from mirror with its full or abbreviated sha1
+func cachedCommit(repo *git.Repository, hashPrefix string) (*object.Commit, error) {
+	hash, err := resolveShortHash(repo, hashPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return repo.CommitObject(hash)
+}
+
+// Write files of ref name from cache in folder
+func (g GitUtils) checkoutFromCache(ctx context.Context) error {
+	commit, unlock, err := g.cacheCommit(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	err = writeCommitFiles(ctx, commit, g.Folder, g.SubPath)
+	if err != nil {
+		return err
+	}
+	return g.completeCheckout(ctx, commit)
+}
+
+// Write files of a commit in dir with their executable bit, symlinks are created as symlinks
+// only files inside sub path are written when it's not empty, they keep their path from repository root
+// submodules are left empty as for a clone
+func writeCommitFiles(ctx context.Context, commit *object.Commit, dir, subPath string) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	prefix := strings.Trim(subPath, "/")
+	if prefix != "" {
+		tree, err = subTree(tree, prefix)
+		if err != nil {
+			return err
+		}
+	}
+	return tree.Files().ForEach(func(file *object.File) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		target, err := extractPath(realDir, path.Join(prefix, file.Name))
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return err
+		}
+		if file.Mode == filemode.Symlink {
+			linkTarget, err := file.Contents()
+			if err != nil {
+				return err
+			}
+			return os.Symlink(linkTarget, target)
+		}
+		var perm os.FileMode = 0644
+		if file.Mode == filemode.Executable {
+			perm = 0755
+		}
+		r, err := file.Reader()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return extractFile(NewContextReader(ctx, r), target, perm)
+	})
+}
+
+// Retrieve tree of folder at path p in tree
+func subTree(tree *object.Tree, p string) (*object.Tree, error) {
+	entry, err := tree.FindEntry(p)
+	if err == object.ErrEntryNotFound || err == object.ErrDirectoryNotFound || (err == nil && entry.Mode != filemode.Dir) {
+		return nil, fmt.Errorf("Sub path %s is not a folder in repository", p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tree.Tree(p)
+}
@@ -0,0 +1,416 @@
+package zipper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	formatcfg "gopkg.in/src-d/go-git.v4/plumbing/format/config"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+const (
+	lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+	// pointer files are small, bigger files are never read to check if they are pointers
+	lfsMaxPointerSize  = 1024
+	lfsMediaType       = "application/vnd.git-lfs+json"
+	lfsBatchMaxObjects = 100
+)
+
+// git lfs object, as given in a pointer file and in batch api
+type lfsObject struct {
+	Oid     string               `json:"oid"`
+	Size    int64                `json:"size"`
+	Actions map[string]lfsAction `json:"actions,omitempty"`
+	Error   *lfsObjectError      `json:"error,omitempty"`
+}
+
+type lfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+type lfsObjectError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lfsBatchRequest struct {
+	Operation string      `json:"operation"`
+	Transfers []string    `json:"transfers"`
+	Objects   []lfsObject `json:"objects"`
+}
+
+type lfsBatchResponse struct {
+	Objects []lfsObject `json:"objects"`
+}
+
+// Replace git lfs pointer files of commit checked out in folder by their content retrieved with lfs batch api
+func (g GitUtils) fetchLfsFiles(ctx context.Context, commit *object.Commit) error {
+	pointers, err := g.lfsPointers(commit)
+	if err != nil {
+		return err
+	}
+	if len(pointers) == 0 {
+		return nil
+	}
+	endpoint, err := g.lfsEndpoint(ctx, commit)
+	if err != nil {
+		return err
+	}
+	objects := make([]lfsObject, 0)
+	seen := make(map[string]bool)
+	for _, pointer := range pointers {
+		if seen[pointer.Oid] {
+			continue
+		}
+		seen[pointer.Oid] = true
+		objects = append(objects, pointer)
+	}
+	downloaded := make(map[string]lfsObject)
+	for start := 0; start < len(objects); start += lfsBatchMaxObjects {
+		end := start + lfsBatchMaxObjects
+		if end > len(objects) {
+			end = len(objects)
+		}
+		batchObjects, err := g.lfsBatch(ctx, endpoint, objects[start:end])
+		if err != nil {
+			return err
+		}
+		for _, object := range batchObjects {
+			downloaded[object.Oid] = object
+		}
+	}
+	realFolder, err := filepath.EvalSymlinks(g.Folder)
+	if err != nil {
+		return err
+	}
+	for name, pointer := range pointers {
+		object, ok := downloaded[pointer.Oid]
+		if !ok {
+			return fmt.Errorf("Git lfs server doesn't give object %s for file %s", pointer.Oid, name)
+		}
+		if object.Error != nil {
+			return fmt.Errorf("Git lfs server can't give object for file %s: %d %s", name, object.Error.Code, object.Error.Message)
+		}
+		target, err := extractPath(realFolder, name)
+		if err != nil {
+			return err
+		}
+		err = g.lfsDownload(ctx, endpoint, object, target)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Retrieve lfs pointers, by file name, from files of commit inside sub path
+func (g GitUtils) lfsPointers(commit *object.Commit) (map[string]lfsObject, error) {
+	files, err := commit.Files()
+	if err != nil {
+		return nil, err
+	}
+	pointers := make(map[string]lfsObject)
+	err = files.ForEach(func(file *object.File) error {
+		if file.Mode == filemode.Symlink || file.Size > lfsMaxPointerSize || !g.inSubPath(file.Name) {
+			return nil
+		}
+		content, err := file.Contents()
+		if err != nil {
+			return err
+		}
+		if pointer, ok := parseLfsPointer(content); ok {
+			pointers[file.Name] = pointer
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pointers, nil
+}
+
+// Parse content of a lfs pointer file (see https://github.com/git-lfs/git-lfs/blob/master/docs/spec.md)
+func parseLfsPointer(content string) (lfsObject, bool) {
+	if !strings.HasPrefix(content, lfsPointerVersion+"\n") {
+		return lfsObject{}, false
+	}
+	pointer := lfsObject{Size: -1}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			return lfsObject{}, false
+		}
+		switch key {
+		case "oid":
+			pointer.Oid = strings.TrimPrefix(value, "sha256:")
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return lfsObject{}, false
+			}
+			pointer.Size = size
+		}
+	}
+	if len(pointer.Oid) != sha256.Size*2 || pointer.Size < 0 {
+		return lfsObject{}, false
+	}
+	return pointer, true
+}
+
+// Url of lfs api, given by lfs.url in .lfsconfig of commit or derived from remote url as git lfs does (e.g.: https://host/repo.git/info/lfs)
+// for ssh remotes, url and headers authenticating requests are given by git-lfs-authenticate run over ssh, as git lfs does
+func (g GitUtils) lfsEndpoint(ctx context.Context, commit *object.Commit) (lfsAction, error) {
+	file, err := commit.File(".lfsconfig")
+	if err != nil && err != object.ErrFileNotFound {
+		return lfsAction{}, err
+	}
+	if err == nil {
+		content, err := file.Contents()
+		if err != nil {
+			return lfsAction{}, err
+		}
+		cfg := formatcfg.New()
+		err = formatcfg.NewDecoder(strings.NewReader(content)).Decode(cfg)
+		if err != nil {
+			return lfsAction{}, err
+		}
+		lfsUrl := cfg.Section("lfs").Option("url")
+		if lfsUrl != "" {
+			return lfsAction{Href: strings.TrimSuffix(lfsUrl, "/")}, nil
+		}
+	}
+	u, err := url.Parse(scpToSshUrl(g.Url))
+	if err != nil {
+		return lfsAction{}, err
+	}
+	var endpoint lfsAction
+	if u.Scheme == "ssh" {
+		endpoint, err = g.lfsSshAuthenticate(ctx, u)
+		if err != nil {
+			return lfsAction{}, err
+		}
+		if endpoint.Href != "" {
+			endpoint.Href = strings.TrimSuffix(endpoint.Href, "/")
+			return endpoint, nil
+		}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		u.Scheme = "https"
+		u.Host = u.Hostname()
+	}
+	u.User = nil
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	if !strings.HasSuffix(u.Path, ".git") {
+		u.Path += ".git"
+	}
+	u.Path += "/info/lfs"
+	endpoint.Href = u.String()
+	return endpoint, nil
+}
+
+// Run git-lfs-authenticate over ssh with auth method used for git to retrieve url and headers of lfs api
+func (g GitUtils) lfsSshAuthenticate(ctx context.Context, u *url.URL) (lfsAction, error) {
+	auth, ok := g.AuthMethod.(ssh.AuthMethod)
+	if !ok {
+		return lfsAction{}, fmt.Errorf("Git lfs needs an ssh auth method to authenticate on %s", u.Host)
+	}
+	config, err := auth.ClientConfig()
+	if err != nil {
+		return lfsAction{}, err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return lfsAction{}, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+	sshConn, channels, requests, err := gossh.NewClientConn(conn, addr, config)
+	if err != nil {
+		return lfsAction{}, err
+	}
+	client := gossh.NewClient(sshConn, channels, requests)
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return lfsAction{}, err
+	}
+	defer session.Close()
+	// path is given as git gives it to git-upload-pack, without leading slash for an url in scp-like syntax
+	path := u.Path
+	if !strings.Contains(g.Url, "://") {
+		path = strings.TrimPrefix(path, "/")
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	out, err := session.Output("git-lfs-authenticate " + shellQuote(path) + " download")
+	if ctx.Err() != nil {
+		return lfsAction{}, ctx.Err()
+	}
+	if err != nil {
+		return lfsAction{}, fmt.Errorf("Git lfs authentication over ssh on %s failed: %s %s", u.Host, err, strings.TrimSpace(stderr.String()))
+	}
+	var endpoint lfsAction
+	err = json.Unmarshal(out, &endpoint)
+	if err != nil {
+		return lfsAction{}, fmt.Errorf("Git lfs authentication over ssh on %s gives an invalid response: %s", u.Host, err)
+	}
+	return endpoint, nil
+}
+
+// Quote a string for a posix shell, as git does for paths given to commands run over ssh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Ask lfs batch api where objects can be downloaded
+func (g GitUtils) lfsBatch(ctx context.Context, endpoint lfsAction, objects []lfsObject) ([]lfsObject, error) {
+	body, err := json.Marshal(lfsBatchRequest{
+		Operation: "download",
+		Transfers: []string{"basic"},
+		Objects:   objects,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint.Href+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", lfsMediaType)
+	req.Header.Set("Content-Type", lfsMediaType)
+	g.setLfsAuth(req, endpoint)
+	resp, err := g.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	err = checkLfsResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	var batchResp lfsBatchResponse
+	err = json.NewDecoder(resp.Body).Decode(&batchResp)
+	if err != nil {
+		return nil, err
+	}
+	return batchResp.Objects, nil
+}
+
+// Download lfs object in target, content is checked against object oid and size
+func (g GitUtils) lfsDownload(ctx context.Context, endpoint lfsAction, object lfsObject, target string) error {
+	action, ok := object.Actions["download"]
+	if !ok {
+		return fmt.Errorf("Git lfs server doesn't give a download action for object %s", object.Oid)
+	}
+	req, err := http.NewRequest(http.MethodGet, action.Href, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for key, value := range action.Header {
+		req.Header.Set(key, value)
+	}
+	// credentials are only given to lfs server itself, not to a storage it redirects to
+	if req.Header.Get("Authorization") == "" && sameHost(endpoint.Href, action.Href) {
+		g.setLfsAuth(req, endpoint)
+	}
+	resp, err := g.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	err = checkLfsResponse(resp)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), NewContextReader(ctx, resp.Body))
+	if err != nil {
+		return err
+	}
+	if size != object.Size || hex.EncodeToString(h.Sum(nil)) != object.Oid {
+		return fmt.Errorf("Content downloaded from git lfs server doesn't match object %s", object.Oid)
+	}
+	return f.Close()
+}
+
+// Authenticate lfs request with headers given for endpoint (e.g.: by git-lfs-authenticate),
+// or with auth method used for git when it's an http one
+func (g GitUtils) setLfsAuth(req *http.Request, endpoint lfsAction) {
+	for key, value := range endpoint.Header {
+		req.Header.Set(key, value)
+	}
+	if req.Header.Get("Authorization") != "" {
+		return
+	}
+	if auth, ok := g.AuthMethod.(githttp.AuthMethod); ok {
+		auth.SetAuth(req)
+	}
+}
+
+func (g GitUtils) httpClient() *http.Client {
+	if g.HttpClient == nil {
+		return http.DefaultClient
+	}
+	return g.HttpClient
+}
+
+func checkLfsResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf(
+		"Error occured when requesting git lfs server %s: %d %s: \n%s",
+		resp.Request.URL.Redacted(),
+		resp.StatusCode,
+		http.StatusText(resp.StatusCode),
+		string(b),
+	)
+}
+
+func sameHost(url1, url2 string) bool {
+	u1, err := url.Parse(url1)
+	if err != nil {
+		return false
+	}
+	u2, err := url.Parse(url2)
+	if err != nil {
+		return false
+	}
+	return u1.Host == u2.Host
+}
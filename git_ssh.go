@@ -0,0 +1,130 @@
+package zipper
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+// Options used for git repositories reached over ssh
+// Without options, ssh agent is used to authenticate (or the key given by private-key query parameter of url)
+// and host keys are verified with known_hosts files from SSH_KNOWN_HOSTS environment variable, or ~/.ssh/known_hosts
+// and /etc/ssh/ssh_known_hosts
+type GitSshOptions struct {
+	// Known hosts files used to verify host keys instead of default ones
+	KnownHostsFiles []string
+	// Fingerprints of accepted host keys, as given by ssh-keygen -l (e.g.: SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8)
+	// legacy md5 fingerprints (e.g.: MD5:16:27:ac:a5:76:28:2d:36:63:1b:56:4d:eb:df:a6:48) are also accepted
+	// When set, known hosts files are only read if they are explicitly given in KnownHostsFiles
+	HostKeyFingerprints []string
+	// Accept any host key, as --insecure does for tls certificates, this must only be used for testing
+	Insecure bool
+	// Private key in PEM format used instead of ssh agent, a private-key query parameter in url is still preferred
+	PrivateKey []byte
+	// Password of private key when it is encrypted
+	PrivateKeyPassword string
+}
+
+// Create callback verifying host keys, nil is returned when default known_hosts files must be used
+func (o *GitSshOptions) hostKeyCallback() (gossh.HostKeyCallback, error) {
+	if o == nil {
+		return nil, nil
+	}
+	if o.Insecure {
+		return gossh.InsecureIgnoreHostKey(), nil
+	}
+	if len(o.HostKeyFingerprints) == 0 && len(o.KnownHostsFiles) == 0 {
+		return nil, nil
+	}
+	var knownHosts gossh.HostKeyCallback
+	if len(o.KnownHostsFiles) > 0 {
+		var err error
+		knownHosts, err = ssh.NewKnownHostsCallback(o.KnownHostsFiles...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	fingerprints := o.HostKeyFingerprints
+	return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		if matchFingerprint(fingerprints, key) {
+			return nil
+		}
+		if knownHosts != nil {
+			return knownHosts(hostname, remote, key)
+		}
+		return fmt.Errorf(
+			"Host key of %s with fingerprint %s doesn't match any of given fingerprints",
+			hostname, gossh.FingerprintSHA256(key),
+		)
+	}, nil
+}
+
+func matchFingerprint(fingerprints []string, key gossh.PublicKey) bool {
+	sha256Fingerprint := gossh.FingerprintSHA256(key)
+	md5Fingerprint := gossh.FingerprintLegacyMD5(key)
+	for _, fingerprint := range fingerprints {
+		fingerprint = strings.TrimSpace(fingerprint)
+		if fingerprint == sha256Fingerprint || strings.TrimPrefix(strings.ToLower(fingerprint), "md5:") == md5Fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// Create auth method for an ssh url: key file given by private-key query parameter, private key from options
+// or ssh agent, in this order, an error is given when none of them can be used (e.g.: ssh agent is not running)
+func sshAuthMethod(uri *url.URL, options *GitSshOptions) (transport.AuthMethod, error) {
+	user := uri.User.Username()
+	var auth transport.AuthMethod
+	switch {
+	case uri.Query().Get("private-key") != "":
+		keys, err := ssh.NewPublicKeysFromFile(user, uri.Query().Get("private-key"), uri.Query().Get("password-key"))
+		if err != nil {
+			return nil, err
+		}
+		auth = keys
+	case options != nil && len(options.PrivateKey) > 0:
+		if user == "" {
+			user = ssh.DefaultUsername
+		}
+		keys, err := ssh.NewPublicKeys(user, options.PrivateKey, options.PrivateKeyPassword)
+		if err != nil {
+			return nil, err
+		}
+		auth = keys
+	default:
+		agent, err := ssh.NewSSHAgentAuth(user)
+		if err != nil {
+			return nil, err
+		}
+		auth = agent
+	}
+	return options.setHostKeyCallback(auth)
+}
+
+// Set callback verifying host keys on an ssh auth method, auth method is given back as it is when options
+// don't ask for a specific verification
+func (o *GitSshOptions) setHostKeyCallback(auth transport.AuthMethod) (transport.AuthMethod, error) {
+	callback, err := o.hostKeyCallback()
+	if err != nil || callback == nil || auth == nil {
+		return auth, err
+	}
+	switch sshAuth := auth.(type) {
+	case *ssh.PublicKeys:
+		sshAuth.HostKeyCallback = callback
+	case *ssh.PublicKeysCallback:
+		sshAuth.HostKeyCallback = callback
+	case *ssh.Password:
+		sshAuth.HostKeyCallback = callback
+	case *ssh.PasswordCallback:
+		sshAuth.HostKeyCallback = callback
+	case *ssh.KeyboardInteractive:
+		sshAuth.HostKeyCallback = callback
+	}
+	return auth, nil
+}
@@ -0,0 +1,127 @@
+package zipper
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/whilp/git-urls"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// Download content of git lfs files and clone submodules, when asked, of commit checked out in folder
+func (g GitUtils) completeCheckout(ctx context.Context, commit *object.Commit) error {
+	if g.Lfs {
+		err := g.fetchLfsFiles(ctx, commit)
+		if err != nil {
+			return err
+		}
+	}
+	if g.Submodules {
+		return g.cloneSubmodules(ctx, commit)
+	}
+	return nil
+}
+
+// Clone submodules declared in .gitmodules of commit, and inside sub path, in their folder at their pinned commit,
+// submodules of submodules are also cloned
+func (g GitUtils) cloneSubmodules(ctx context.Context, commit *object.Commit) error {
+	file, err := commit.File(".gitmodules")
+	if err == object.ErrFileNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	content, err := file.Contents()
+	if err != nil {
+		return err
+	}
+	modules := config.NewModules()
+	err = modules.Unmarshal([]byte(content))
+	if err != nil {
+		return err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	realFolder, err := filepath.EvalSymlinks(g.Folder)
+	if err != nil {
+		return err
+	}
+	for _, submodule := range modules.Submodules {
+		if !g.inSubPath(submodule.Path) {
+			continue
+		}
+		entry, err := tree.FindEntry(submodule.Path)
+		if err != nil || entry.Mode != filemode.Submodule {
+			// submodule declared but not in tree (e.g.: removed without updating .gitmodules)
+			continue
+		}
+		folder, err := extractPath(realFolder, submodule.Path)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(folder, 0755)
+		if err != nil {
+			return err
+		}
+		subUtils := g
+		subUtils.Folder = folder
+		subUtils.Url = resolveSubmoduleUrl(g.Url, submodule.URL)
+		subUtils.RefName = entry.Hash.String()
+		subUtils.SubPath = ""
+		// auth of parent repository is only given to submodules on the same host
+		if !sameGitProtocol(g.Url, subUtils.Url) || !sameHost(scpToSshUrl(g.Url), scpToSshUrl(subUtils.Url)) {
+			subUtils.AuthMethod = nil
+			if u, err := giturls.Parse(subUtils.Url); err == nil {
+				subUtils.AuthMethod, err = createGitAuthMethod(u, g.SshOptions)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		err = subUtils.CloneContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resolve submodule url relative to url of its parent repository (e.g.: ../other.git)
+func resolveSubmoduleUrl(parentUrl, subUrl string) string {
+	if !strings.HasPrefix(subUrl, "./") && !strings.HasPrefix(subUrl, "../") {
+		return subUrl
+	}
+	if !strings.Contains(parentUrl, "://") && scpSyntax.MatchString(parentUrl) {
+		matches := scpSyntax.FindStringSubmatch(parentUrl)
+		return matches[1] + matches[2] + ":" + path.Join(matches[3], subUrl)
+	}
+	u, err := url.Parse(parentUrl)
+	if err != nil {
+		return path.Join(parentUrl, subUrl)
+	}
+	u.Path = path.Join(u.Path, subUrl)
+	return u.String()
+}
+
+// check if both urls use the same transport, an auth method can only be used for one transport
+func sameGitProtocol(url1, url2 string) bool {
+	endpoint1, err := transport.NewEndpoint(url1)
+	if err != nil {
+		return false
+	}
+	endpoint2, err := transport.NewEndpoint(url2)
+	if err != nil {
+		return false
+	}
+	return endpoint1.Protocol == endpoint2.Protocol
+}
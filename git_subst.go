@@ -0,0 +1,168 @@
+package zipper
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+const (
+	exportSubstStart = "$Format:"
+	// length of abbreviated sha1, as git uses by default for small repositories
+	abbrevHashLen = 7
+)
+
+// Replace $Format:...$ placeholders in content of a file with export-subst attribute by their value for commit, as git archive does
+// placeholders supported are the ones of git log --pretty=format which don't depend on references or on other commits
+// (e.g.: %H, %h, %an, %ad, %s), unsupported ones are kept as they are
+func expandExportSubst(content []byte, commit *object.Commit) []byte {
+	var buf bytes.Buffer
+	for {
+		start := bytes.Index(content, []byte(exportSubstStart))
+		if start < 0 {
+			break
+		}
+		formatStart := start + len(exportSubstStart)
+		end := bytes.IndexByte(content[formatStart:], '$')
+		if end < 0 {
+			break
+		}
+		buf.Write(content[:start])
+		buf.WriteString(formatCommit(string(content[formatStart:formatStart+end]), commit))
+		content = content[formatStart+end+1:]
+	}
+	buf.Write(content)
+	return buf.Bytes()
+}
+
+// Format commit as git log --pretty=format does
+func formatCommit(format string, commit *object.Commit) string {
+	var buf strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			buf.WriteByte(format[i])
+			continue
+		}
+		value, length := commitPlaceholder(format[i+1:], commit)
+		if length == 0 {
+			buf.WriteByte('%')
+			continue
+		}
+		buf.WriteString(value)
+		i += length
+	}
+	return buf.String()
+}
+
+// Retrieve value of placeholder at the start of format (without %) and its length, length is 0 when placeholder is unknown
+func commitPlaceholder(format string, commit *object.Commit) (string, int) {
+	if format == "" {
+		return "", 0
+	}
+	switch format[0] {
+	case '%':
+		return "%", 1
+	case 'n':
+		return "\n", 1
+	case 'x':
+		if len(format) < 3 {
+			return "", 0
+		}
+		b, err := hex.DecodeString(format[1:3])
+		if err != nil {
+			return "", 0
+		}
+		return string(b), 3
+	case 'H':
+		return commit.Hash.String(), 1
+	case 'h':
+		return abbrevHash(commit.Hash), 1
+	case 'T':
+		return commit.TreeHash.String(), 1
+	case 't':
+		return abbrevHash(commit.TreeHash), 1
+	case 'P', 'p':
+		parents := make([]string, 0, len(commit.ParentHashes))
+		for _, parent := range commit.ParentHashes {
+			if format[0] == 'p' {
+				parents = append(parents, abbrevHash(parent))
+				continue
+			}
+			parents = append(parents, parent.String())
+		}
+		return strings.Join(parents, " "), 1
+	case 'a', 'c':
+		if len(format) < 2 {
+			return "", 0
+		}
+		signature := commit.Author
+		if format[0] == 'c' {
+			signature = commit.Committer
+		}
+		value, ok := signaturePlaceholder(format[1], signature)
+		if !ok {
+			return "", 0
+		}
+		return value, 2
+	case 's':
+		subject, _ := splitCommitMessage(commit.Message)
+		return subject, 1
+	case 'b':
+		_, body := splitCommitMessage(commit.Message)
+		return body, 1
+	case 'B':
+		return commit.Message, 1
+	}
+	return "", 0
+}
+
+func signaturePlaceholder(c byte, signature object.Signature) (string, bool) {
+	when := signature.When
+	switch c {
+	case 'n', 'N':
+		return signature.Name, true
+	case 'e', 'E':
+		return signature.Email, true
+	case 'l', 'L':
+		return strings.SplitN(signature.Email, "@", 2)[0], true
+	case 'd':
+		return when.Format("Mon Jan 2 15:04:05 2006 -0700"), true
+	case 'D':
+		return when.Format("Mon, 2 Jan 2006 15:04:05 -0700"), true
+	case 'i':
+		return when.Format("2006-01-02 15:04:05 -0700"), true
+	case 'I':
+		return when.Format("2006-01-02T15:04:05-07:00"), true
+	case 's':
+		return when.Format("2006-01-02"), true
+	case 't':
+		return strconv.FormatInt(when.Unix(), 10), true
+	}
+	return "", false
+}
+
+// Split commit message in its subject, the first paragraph on one line, and its body, the rest of message
+func splitCommitMessage(message string) (string, string) {
+	lines := strings.SplitAfter(message, "\n")
+	// leading blank lines are not part of subject
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	subject := make([]string, 0)
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) != "" {
+		subject = append(subject, strings.TrimSpace(lines[0]))
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	return strings.Join(subject, " "), strings.Join(lines, "")
+}
+
+func abbrevHash(hash plumbing.Hash) string {
+	return hash.String()[:abbrevHashLen]
+}
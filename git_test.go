@@ -1,12 +1,37 @@
 package zipper_test
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	. "github.com/ArthurHlt/zipper"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -14,6 +39,323 @@ const (
 	fixtureRepoSsh = "ssh://git@github.com:ArthurHlt/zipper-fixture.git"
 )
 
+func pktLine(line string) string {
+	return fmt.Sprintf("%04x%s", len(line)+4, line)
+}
+
+// Local repository built with git binary and served with git smart http protocol
+type gitFixture struct {
+	work    string
+	bare    string
+	server  *httptest.Server
+	uploads int32
+	// git lfs objects served by lfs batch api of server, by oid
+	lfsObjects map[string][]byte
+	// Authorization header received by lfs batch api
+	lfsAuth string
+	// basic auth, as user:password, required by server when set
+	auth string
+}
+
+func newGitFixture() *gitFixture {
+	gitBin, err := exec.LookPath("git")
+	if err != nil {
+		Skip("git binary is needed to build local repositories")
+	}
+	root, err := ioutil.TempDir("", "git-fixture")
+	Expect(err).NotTo(HaveOccurred())
+	f := &gitFixture{
+		work:       filepath.Join(root, "work"),
+		bare:       filepath.Join(root, "repo.git"),
+		lfsObjects: make(map[string][]byte),
+	}
+	runGit(root, "init", "-q", "-b", "master", f.work)
+	runGit(root, "init", "-q", "--bare", "-b", "master", f.bare)
+	backend := &cgi.Handler{
+		Path: gitBin,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if f.auth != "" {
+			user, password, _ := req.BasicAuth()
+			if user+":"+password != f.auth {
+				w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		switch {
+		case req.URL.Path == "/repo.git/info/lfs/objects/batch":
+			f.serveLfsBatch(w, req)
+			return
+		case strings.HasPrefix(req.URL.Path, "/lfs/"):
+			w.Write(f.lfsObjects[strings.TrimPrefix(req.URL.Path, "/lfs/")])
+			return
+		case strings.HasSuffix(req.URL.Path, "/git-upload-pack"):
+			atomic.AddInt32(&f.uploads, 1)
+		}
+		backend.ServeHTTP(w, req)
+	}))
+	return f
+}
+
+func (f *gitFixture) serveLfsBatch(w http.ResponseWriter, req *http.Request) {
+	f.lfsAuth = req.Header.Get("Authorization")
+	var batch struct {
+		Objects []struct {
+			Oid  string `json:"oid"`
+			Size int64  `json:"size"`
+		} `json:"objects"`
+	}
+	Expect(json.NewDecoder(req.Body).Decode(&batch)).To(Succeed())
+	objects := make([]interface{}, 0)
+	for _, object := range batch.Objects {
+		objects = append(objects, map[string]interface{}{
+			"oid":  object.Oid,
+			"size": object.Size,
+			"actions": map[string]interface{}{
+				"download": map[string]interface{}{"href": f.server.URL + "/lfs/" + object.Oid},
+			},
+		})
+	}
+	w.Header().Set("Content-Type", "application/vnd.git-lfs+json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"objects": objects})
+}
+
+// Store content in lfs server and give pointer file content to commit instead of it
+func (f *gitFixture) LfsPointer(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	oid := hex.EncodeToString(sum[:])
+	f.lfsObjects[oid] = []byte(content)
+	return fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %d\n", oid, len(content))
+}
+
+// Url of repository with an optional fragment or sub path
+func (f *gitFixture) Url(suffix string) string {
+	return f.server.URL + "/repo.git" + suffix
+}
+
+// Number of upload-pack requests, made to fetch objects, received by server
+func (f *gitFixture) Uploads() int {
+	return int(atomic.LoadInt32(&f.uploads))
+}
+
+// Commit given files and push every refs to served repository, commit sha1 is returned
+func (f *gitFixture) Commit(files map[string]string) string {
+	for name, content := range files {
+		path := filepath.Join(f.work, filepath.FromSlash(name))
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(content), 0644)).To(Succeed())
+	}
+	f.Git("add", "-A")
+	f.Git("commit", "-q", "-m", "commit")
+	f.Push()
+	return f.Git("rev-parse", "HEAD")
+}
+
+// Push every refs of working repository to served repository
+func (f *gitFixture) Push() {
+	f.Git("push", "-q", "--mirror", f.bare)
+}
+
+// Rename current branch and make it the default branch of served repository
+func (f *gitFixture) SetDefaultBranch(name string) {
+	f.Git("branch", "-m", name)
+	runGit(f.bare, "symbolic-ref", "HEAD", "refs/heads/"+name)
+	f.Push()
+}
+
+// Run git in working repository
+func (f *gitFixture) Git(args ...string) string {
+	return runGit(f.work, args...)
+}
+
+func (f *gitFixture) Close() {
+	f.server.Close()
+	os.RemoveAll(filepath.Dir(f.work))
+}
+
+// Ssh server running git upload-pack on bare repository of a git fixture, only clients using its client key are accepted
+type sshGitServer struct {
+	listener net.Listener
+	config   *gossh.ServerConfig
+	root     string
+	HostKey  gossh.PublicKey
+	// private key in PEM format accepted by server
+	ClientKey []byte
+	// lfs api given by git-lfs-authenticate, command is refused when empty
+	LfsHref string
+	// path received by git-lfs-authenticate
+	LfsPath string
+}
+
+func newSshGitServer(fixture *gitFixture) *sshGitServer {
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	hostSigner, err := gossh.NewSignerFromKey(hostKey)
+	Expect(err).NotTo(HaveOccurred())
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	clientPublicKey, err := gossh.NewPublicKey(&clientKey.PublicKey)
+	Expect(err).NotTo(HaveOccurred())
+	clientKeyDer, err := x509.MarshalECPrivateKey(clientKey)
+	Expect(err).NotTo(HaveOccurred())
+
+	config := &gossh.ServerConfig{
+		PublicKeyCallback: func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), clientPublicKey.Marshal()) {
+				return nil, fmt.Errorf("unknown public key for %s", conn.User())
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	s := &sshGitServer{
+		listener:  listener,
+		config:    config,
+		root:      filepath.Dir(fixture.bare),
+		HostKey:   hostSigner.PublicKey(),
+		ClientKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: clientKeyDer}),
+	}
+	go s.serve()
+	return s
+}
+
+func (s *sshGitServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *sshGitServer) handle(conn net.Conn) {
+	_, channels, requests, err := gossh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go gossh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(gossh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.session(channel, channelRequests)
+	}
+}
+
+// run exec request as git upload-pack '/repo.git' does
+func (s *sshGitServer) session(channel gossh.Channel, requests <-chan *gossh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(req.Type == "env", nil)
+			continue
+		}
+		var payload struct{ Command string }
+		gossh.Unmarshal(req.Payload, &payload)
+		command := strings.SplitN(payload.Command, " ", 2)
+		if len(command) == 2 && command[0] == "git-lfs-authenticate" && s.LfsHref != "" {
+			req.Reply(true, nil)
+			s.LfsPath = strings.TrimSuffix(command[1], " download")
+			json.NewEncoder(channel).Encode(map[string]interface{}{
+				"href":   s.LfsHref,
+				"header": map[string]string{"Authorization": "RemoteAuth ssh-token"},
+			})
+			channel.SendRequest("exit-status", false, gossh.Marshal(struct{ Status uint32 }{0}))
+			return
+		}
+		if len(command) != 2 || command[0] != "git-upload-pack" {
+			req.Reply(false, nil)
+			return
+		}
+		req.Reply(true, nil)
+		repo := filepath.Join(s.root, filepath.FromSlash(strings.Trim(command[1], "'/")))
+		cmd := exec.Command("git", "upload-pack", repo)
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return
+		}
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+		status := 0
+		if err := cmd.Start(); err != nil {
+			status = 1
+		} else {
+			go func() {
+				io.Copy(stdin, channel)
+				stdin.Close()
+			}()
+			if err := cmd.Wait(); err != nil {
+				status = 1
+			}
+		}
+		channel.SendRequest("exit-status", false, gossh.Marshal(struct{ Status uint32 }{uint32(status)}))
+		return
+	}
+}
+
+// Url of repository served over ssh
+func (s *sshGitServer) Url(suffix string) string {
+	return "ssh://git@" + s.listener.Addr().String() + "/repo.git" + suffix
+}
+
+// Line of a known_hosts file for host key of server
+func (s *sshGitServer) KnownHostsLine() string {
+	return knownhosts.Line([]string{s.listener.Addr().String()}, s.HostKey) + "\n"
+}
+
+func (s *sshGitServer) Close() {
+	s.listener.Close()
+}
+
+func runGit(dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_NOSYSTEM=1",
+		"HOME="+dir,
+		"GIT_AUTHOR_NAME=zipper",
+		"GIT_AUTHOR_EMAIL=zipper@example.com",
+		"GIT_COMMITTER_NAME=zipper",
+		"GIT_COMMITTER_EMAIL=zipper@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	ExpectWithOffset(1, err).NotTo(HaveOccurred(), string(out))
+	return strings.TrimSpace(string(out))
+}
+
+func filesInZipByName(zipFile ZipReadCloser) map[string]*zip.File {
+	defer zipFile.Close()
+	b, err := ioutil.ReadAll(zipFile)
+	Expect(err).NotTo(HaveOccurred())
+	reader, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	Expect(err).NotTo(HaveOccurred())
+	files := make(map[string]*zip.File)
+	for _, file := range reader.File {
+		files[file.Name] = file
+	}
+	return files
+}
+
+func namesInZip(zipFile ZipReadCloser) []string {
+	names := make([]string, 0)
+	for name := range filesInZipByName(zipFile) {
+		names = append(names, name)
+	}
+	return names
+}
+
 var _ = Describe("Git", func() {
 	var handler *GitHandler
 	BeforeEach(func() {
@@ -65,7 +407,98 @@ var _ = Describe("Git", func() {
 			Expect(sha1).Should(Equal("eb3bb57ba0e7da0069ad673b3c3a988484d0291c"))
 		})
 	})
+	Describe("Sha1 from advertised references", func() {
+		var fixture *gitFixture
+		var firstCommit string
+		var secondCommit string
+		BeforeEach(func() {
+			fixture = newGitFixture()
+			firstCommit = fixture.Commit(map[string]string{"README.md": "readme"})
+			fixture.Git("tag", "v0.0.1")
+			fixture.Git("tag", "-a", "-m", "annotated", "v0.0.2")
+			fixture.Git("checkout", "-q", "-b", "test-branch")
+			secondCommit = fixture.Commit(map[string]string{"branch.txt": "branch"})
+			fixture.Git("checkout", "-q", "master")
+			fixture.Push()
+		})
+		AfterEach(func() {
+			fixture.Close()
+		})
+		sha1 := func(suffix string) string {
+			src := NewSource(fixture.Url(suffix))
+			SetCtxHttpClient(src, http.DefaultClient)
+			sha1, err := handler.Sha1(src)
+			Expect(err).NotTo(HaveOccurred())
+			return sha1
+		}
+
+		It("should resolve branches, tags and HEAD without fetching objects", func() {
+			Expect(sha1("")).To(Equal(firstCommit))
+			Expect(sha1("#HEAD")).To(Equal(firstCommit))
+			Expect(sha1("#test-branch")).To(Equal(secondCommit))
+			Expect(sha1("#v0.0.1")).To(Equal(firstCommit))
+			Expect(fixture.Uploads()).To(BeZero())
+		})
+		It("should peel annotated tags to their commit", func() {
+			Expect(fixture.Git("rev-parse", "v0.0.2")).NotTo(Equal(firstCommit))
+			Expect(sha1("#v0.0.2")).To(Equal(firstCommit))
+		})
+		It("should return an error when ref doesn't exist", func() {
+			src := NewSource(fixture.Url("#unknown"))
+			SetCtxHttpClient(src, http.DefaultClient)
+			_, err := handler.Sha1(src)
+			Expect(err).To(HaveOccurred())
+		})
+		It("should abort when context is cancelled", func() {
+			stop := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				<-stop
+			}))
+			defer server.Close()
+			defer close(stop)
+			ctx, cancel := context.WithCancel(context.Background())
+			src := NewSource(server.URL + "/repo.git").WithContext(ctx)
+			SetCtxHttpClient(src, server.Client())
+			time.AfterFunc(200*time.Millisecond, cancel)
+
+			_, err := handler.Sha1(src)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(context.Canceled.Error()))
+		})
+	})
 	Describe("Zip", func() {
+		Context("When context is cancelled during clone", func() {
+			var server *httptest.Server
+			BeforeEach(func() {
+				server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					if strings.HasSuffix(req.URL.Path, "/info/refs") {
+						hash := "eb3bb57ba0e7da0069ad673b3c3a988484d0291b"
+						w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+						w.Write([]byte(
+							pktLine("# service=git-upload-pack\n") + "0000" +
+								pktLine(hash+" HEAD\x00multi_ack ofs-delta side-band-64k shallow\n") +
+								pktLine(hash+" refs/heads/master\n") + "0000",
+						))
+						return
+					}
+					ioutil.ReadAll(req.Body)
+					<-req.Context().Done()
+				}))
+			})
+			AfterEach(func() {
+				server.Close()
+			})
+			It("should abort clone", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				src := NewSource(fmt.Sprintf("http://%s/repo.git", server.Listener.Addr().String())).WithContext(ctx)
+				SetCtxHttpClient(src, server.Client())
+				time.AfterFunc(200*time.Millisecond, cancel)
+
+				_, err := handler.Zip(src)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring(context.Canceled.Error()))
+			})
+		})
 		Context("When is http source url", func() {
 			It("should create zip file", func() {
 				src := NewSource(fixtureRepo)
@@ -104,6 +537,811 @@ var _ = Describe("Git", func() {
 				checkZipFile(zipFile, "README.md", "branch.txt")
 			})
 		})
+		Context("When a cache dir is set", func() {
+			var fixture *gitFixture
+			var cacheDir string
+			var firstCommit string
+			BeforeEach(func() {
+				fixture = newGitFixture()
+				firstCommit = fixture.Commit(map[string]string{"README.md": "readme", "run.sh": "#!/bin/sh"})
+				var err error
+				cacheDir, err = ioutil.TempDir("", "git-cache")
+				Expect(err).NotTo(HaveOccurred())
+			})
+			AfterEach(func() {
+				fixture.Close()
+				os.RemoveAll(cacheDir)
+			})
+			newSrc := func(suffix string) *Source {
+				src := NewSource(fixture.Url(suffix))
+				SetCtxHttpClient(src, http.DefaultClient)
+				SetCtxGitCacheDir(src, cacheDir)
+				return src
+			}
+
+			It("should only fetch new objects from remote", func() {
+				zipFile, err := handler.Zip(newSrc(""))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(namesInZip(zipFile)).To(ConsistOf("README.md", "run.sh"))
+				uploads := fixture.Uploads()
+				Expect(uploads).To(BeNumerically(">", 0))
+
+				sha1, err := handler.Sha1(newSrc(""))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sha1).To(Equal(firstCommit))
+				zipFile, err = handler.Zip(newSrc(""))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(namesInZip(zipFile)).To(ConsistOf("README.md", "run.sh"))
+				Expect(fixture.Uploads()).To(Equal(uploads))
+
+				secondCommit := fixture.Commit(map[string]string{"new.txt": "new"})
+				sha1, err = handler.Sha1(newSrc(""))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sha1).To(Equal(secondCommit))
+				zipFile, err = handler.Zip(newSrc(""))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(namesInZip(zipFile)).To(ConsistOf("README.md", "run.sh", "new.txt"))
+				Expect(fixture.Uploads()).To(BeNumerically(">", uploads))
+			})
+			It("should checkout a commit already in cache without fetching remote", func() {
+				fixture.Commit(map[string]string{"new.txt": "new"})
+				zipFile, err := handler.Zip(newSrc(""))
+				Expect(err).NotTo(HaveOccurred())
+				zipFile.Close()
+				uploads := fixture.Uploads()
+
+				zipFile, err = handler.Zip(newSrc("#" + firstCommit))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(namesInZip(zipFile)).To(ConsistOf("README.md", "run.sh"))
+				Expect(fixture.Uploads()).To(Equal(uploads))
+			})
+			It("should keep executable bit of files", func() {
+				fixture.Git("update-index", "--chmod=+x", "run.sh")
+				fixture.Git("commit", "-q", "-m", "executable")
+				fixture.Push()
+				zipFile, err := handler.Zip(newSrc(""))
+				Expect(err).NotTo(HaveOccurred())
+				fis := filesInZipByName(zipFile)
+				Expect(fis["run.sh"].Mode() & 0100).ToNot(BeZero())
+				Expect(fis["README.md"].Mode() & 0100).To(BeZero())
+			})
+			It("should be usable concurrently", func() {
+				wg := &sync.WaitGroup{}
+				errs := make(chan error, 4)
+				for i := 0; i < 4; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						zipFile, err := handler.Zip(newSrc(""))
+						if err == nil {
+							zipFile.Close()
+						}
+						errs <- err
+					}()
+				}
+				wg.Wait()
+				close(errs)
+				for err := range errs {
+					Expect(err).NotTo(HaveOccurred())
+				}
+			})
+		})
+		Context("When using refs from a local repository", func() {
+			var fixture *gitFixture
+			var mainCommit string
+			BeforeEach(func() {
+				fixture = newGitFixture()
+				mainCommit = fixture.Commit(map[string]string{"README.md": "readme"})
+				fixture.SetDefaultBranch("main")
+				fixture.Git("checkout", "-q", "-b", "Release-1.2")
+				fixture.Commit(map[string]string{"release.txt": "release"})
+				fixture.Git("checkout", "-q", "-b", "feature/x", "main")
+				fixture.Commit(map[string]string{"feature.txt": "feature"})
+				fixture.Git("checkout", "-q", "--detach", "main")
+				pullCommit := fixture.Commit(map[string]string{"pull.txt": "pull"})
+				fixture.Git("update-ref", "refs/pull/42/head", pullCommit)
+				fixture.Git("checkout", "-q", "main")
+				fixture.Push()
+			})
+			AfterEach(func() {
+				fixture.Close()
+			})
+			zip := func(suffix string, cacheDir string) []string {
+				src := NewSource(fixture.Url(suffix))
+				SetCtxHttpClient(src, http.DefaultClient)
+				SetCtxGitCacheDir(src, cacheDir)
+				zipFile, err := handler.Zip(src)
+				Expect(err).NotTo(HaveOccurred())
+				return namesInZip(zipFile)
+			}
+
+			It("should use default branch of remote when no ref is given", func() {
+				Expect(zip("", "")).To(ConsistOf("README.md"))
+
+				src := NewSource(fixture.Url(""))
+				SetCtxHttpClient(src, http.DefaultClient)
+				sha1, err := handler.Sha1(src)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sha1).To(Equal(mainCommit))
+			})
+			It("should keep case of ref name", func() {
+				Expect(zip("#Release-1.2", "")).To(ConsistOf("README.md", "release.txt"))
+			})
+			It("should accept fully-qualified refs", func() {
+				Expect(zip("#refs/heads/feature/x", "")).To(ConsistOf("README.md", "feature.txt"))
+				Expect(zip("#refs/pull/42/head", "")).To(ConsistOf("README.md", "pull.txt"))
+			})
+			It("should accept fully-qualified refs when using a cache", func() {
+				cacheDir, err := ioutil.TempDir("", "git-cache")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(cacheDir)
+
+				Expect(zip("", cacheDir)).To(ConsistOf("README.md"))
+				Expect(zip("#refs/pull/42/head", cacheDir)).To(ConsistOf("README.md", "pull.txt"))
+			})
+			It("should not hold lock on cache while a zip is still open", func() {
+				cacheDir, err := ioutil.TempDir("", "git-cache")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(cacheDir)
+
+				for _, streaming := range []bool{false, true} {
+					ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+					openZip := func() ZipReadCloser {
+						src := NewSource(fixture.Url("")).WithContext(ctx)
+						SetCtxHttpClient(src, http.DefaultClient)
+						SetCtxGitCacheDir(src, cacheDir)
+						SetCtxStreaming(src, streaming)
+						zipFile, err := handler.Zip(src)
+						Expect(err).NotTo(HaveOccurred())
+						return zipFile
+					}
+					first := openZip()
+					if streaming {
+						// lock is kept while zip is written in the pipe, until it is entirely read
+						b, err := ioutil.ReadAll(first)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(bytes.HasPrefix(b, []byte("PK"))).To(BeTrue())
+					}
+					second := openZip()
+					Expect(namesInZip(second)).To(ConsistOf("README.md"))
+					if !streaming {
+						Expect(namesInZip(first)).To(ConsistOf("README.md"))
+					} else {
+						Expect(first.Close()).To(Succeed())
+					}
+					cancel()
+				}
+			})
+		})
+		Context("When using commits from a local repository", func() {
+			var fixture *gitFixture
+			var firstCommit string
+			var lastCommit string
+			BeforeEach(func() {
+				fixture = newGitFixture()
+				firstCommit = fixture.Commit(map[string]string{"README.md": "readme"})
+				fixture.Git("tag", "-a", "-m", "annotated", "v0.0.1")
+				lastCommit = fixture.Commit(map[string]string{"last.txt": "last"})
+			})
+			AfterEach(func() {
+				fixture.Close()
+			})
+			newSrc := func(suffix string) *Source {
+				src := NewSource(fixture.Url(suffix))
+				SetCtxHttpClient(src, http.DefaultClient)
+				return src
+			}
+			zip := func(src *Source) []string {
+				zipFile, err := handler.Zip(src)
+				Expect(err).NotTo(HaveOccurred())
+				return namesInZip(zipFile)
+			}
+
+			It("should create zip file from an annotated tag", func() {
+				Expect(zip(newSrc("#v0.0.1"))).To(ConsistOf("README.md"))
+			})
+			It("should create zip file from an abbreviated commit", func() {
+				Expect(zip(newSrc("#" + firstCommit[:7]))).To(ConsistOf("README.md"))
+				Expect(zip(newSrc("#" + lastCommit[:7]))).To(ConsistOf("README.md", "last.txt"))
+			})
+			It("should create zip file from an abbreviated commit when using a cache", func() {
+				cacheDir, err := ioutil.TempDir("", "git-cache")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(cacheDir)
+				src := newSrc("#" + firstCommit[:7])
+				SetCtxGitCacheDir(src, cacheDir)
+				Expect(zip(src)).To(ConsistOf("README.md"))
+			})
+			It("should expand abbreviated commit in sha1", func() {
+				sha1, err := handler.Sha1(newSrc("#" + lastCommit[:7]))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sha1).To(Equal(lastCommit))
+				Expect(fixture.Uploads()).To(BeZero())
+
+				sha1, err = handler.Sha1(newSrc("#" + firstCommit[:7]))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sha1).To(Equal(firstCommit))
+			})
+			It("should only fetch the commit when remote allows it", func() {
+				runGit(fixture.bare, "config", "uploadpack.allowReachableSHA1InWant", "true")
+				folder, err := ioutil.TempDir("", "git-clone")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(folder)
+				gitUtils := &GitUtils{
+					Folder:  folder,
+					Url:     fixture.Url(""),
+					RefName: lastCommit,
+				}
+				Expect(gitUtils.Clone()).To(Succeed())
+				Expect(filepath.Join(folder, ".git", "shallow")).To(BeAnExistingFile())
+				Expect(filepath.Join(folder, "last.txt")).To(BeAnExistingFile())
+			})
+			It("should clone entire history to find commit when remote doesn't allow to fetch it alone", func() {
+				Expect(zip(newSrc("#" + firstCommit))).To(ConsistOf("README.md"))
+			})
+		})
+		Context("When repository contains submodules", func() {
+			var fixture *gitFixture
+			var subFixture *gitFixture
+			BeforeEach(func() {
+				subFixture = newGitFixture()
+				subFixture.Commit(map[string]string{"sub.txt": "sub"})
+				fixture = newGitFixture()
+				fixture.Commit(map[string]string{"README.md": "readme"})
+				fixture.Git("submodule", "add", "-q", subFixture.Url(""), "modules/sub")
+				fixture.Git("commit", "-q", "-m", "submodule")
+				fixture.Push()
+				subFixture.Commit(map[string]string{"later.txt": "later"})
+			})
+			AfterEach(func() {
+				fixture.Close()
+				subFixture.Close()
+			})
+			zip := func(src *Source) []string {
+				SetCtxHttpClient(src, http.DefaultClient)
+				zipFile, err := handler.Zip(src)
+				Expect(err).NotTo(HaveOccurred())
+				return namesInZip(zipFile)
+			}
+
+			It("should leave submodules empty by default", func() {
+				names := zip(NewSource(fixture.Url("")))
+				Expect(names).To(ContainElement("README.md"))
+				Expect(names).NotTo(ContainElement("modules/sub/sub.txt"))
+			})
+			It("should clone submodules at their pinned commit when asked in url", func() {
+				names := zip(NewSource(fixture.Url("?submodules=true")))
+				Expect(names).To(ContainElement("modules/sub/sub.txt"))
+				Expect(names).NotTo(ContainElement("modules/sub/later.txt"))
+				for _, name := range names {
+					Expect(name).NotTo(ContainSubstring(".git/"))
+				}
+			})
+			It("should clone submodules when asked on source and using a cache", func() {
+				cacheDir, err := ioutil.TempDir("", "git-cache")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(cacheDir)
+				src := NewSource(fixture.Url(""))
+				SetCtxGitSubmodules(src, true)
+				SetCtxGitCacheDir(src, cacheDir)
+				names := zip(src)
+				Expect(names).To(ContainElement("modules/sub/sub.txt"))
+				Expect(names).NotTo(ContainElement("modules/sub/later.txt"))
+			})
+		})
+		Context("When repository contains git lfs files", func() {
+			var fixture *gitFixture
+			BeforeEach(func() {
+				fixture = newGitFixture()
+				fixture.Commit(map[string]string{
+					"README.md":      "readme",
+					"assets/big.bin": fixture.LfsPointer("large content"),
+				})
+			})
+			AfterEach(func() {
+				fixture.Close()
+			})
+			contentOf := func(src *Source, name string) string {
+				SetCtxHttpClient(src, http.DefaultClient)
+				zipFile, err := handler.Zip(src)
+				Expect(err).NotTo(HaveOccurred())
+				file, ok := filesInZipByName(zipFile)[name]
+				Expect(ok).To(BeTrue())
+				r, err := file.Open()
+				Expect(err).NotTo(HaveOccurred())
+				defer r.Close()
+				b, err := ioutil.ReadAll(r)
+				Expect(err).NotTo(HaveOccurred())
+				return string(b)
+			}
+
+			It("should keep pointer files by default", func() {
+				Expect(contentOf(NewSource(fixture.Url("")), "assets/big.bin")).To(HavePrefix("version https://git-lfs.github.com/spec/v1"))
+			})
+			It("should replace pointer files by their content when asked in url with git credentials", func() {
+				url := strings.Replace(fixture.Url("?lfs=true"), "http://", "http://user:password@", 1)
+				Expect(contentOf(NewSource(url), "assets/big.bin")).To(Equal("large content"))
+				Expect(fixture.lfsAuth).To(HavePrefix("Basic "))
+			})
+			It("should replace pointer files by their content when asked on source and using a cache", func() {
+				cacheDir, err := ioutil.TempDir("", "git-cache")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(cacheDir)
+				src := NewSource(fixture.Url(""))
+				SetCtxGitLfs(src, true)
+				SetCtxGitCacheDir(src, cacheDir)
+				Expect(contentOf(src, "assets/big.bin")).To(Equal("large content"))
+			})
+		})
+		Context("When zipping a commit without working tree", func() {
+			var fixture *gitFixture
+			BeforeEach(func() {
+				fixture = newGitFixture()
+				Expect(os.MkdirAll(filepath.Join(fixture.work, "bin"), 0755)).To(Succeed())
+				Expect(os.Symlink("../README.md", filepath.Join(fixture.work, "bin", "link"))).To(Succeed())
+				fixture.Commit(map[string]string{
+					"README.md":            "readme",
+					"bin/run.sh":           "#!/bin/sh",
+					".gitignore":           "*.log\n",
+					"app.log":              "tracked even if ignored",
+					".zipignore":           "secret.txt\n",
+					"secret.txt":           "secret",
+					"sub/.zipignore":       "local.txt\n",
+					"sub/local.txt":        "local",
+					"sub/kept.txt":         "kept",
+					".gitattributes":       "*.md5 export-ignore\n/docs export-ignore\n[attr]no-export export-ignore\n",
+					"file.md5":             "md5",
+					"docs/index.html":      "docs",
+					"tests/.gitattributes": "*.txt export-ignore\nfixture.txt no-export\ndata.txt -export-ignore\n",
+					"tests/fixture.txt":    "fixture",
+					"tests/data.txt":       "data",
+				})
+				fixture.Git("add", "-f", "app.log")
+				fixture.Git("update-index", "--chmod=+x", "bin/run.sh")
+				fixture.Git("commit", "-q", "-m", "executable")
+				fixture.Push()
+			})
+			AfterEach(func() {
+				fixture.Close()
+			})
+			zipFiles := func(src *Source) map[string]*zip.File {
+				SetCtxHttpClient(src, http.DefaultClient)
+				zipFile, err := handler.Zip(src)
+				Expect(err).NotTo(HaveOccurred())
+				return filesInZipByName(zipFile)
+			}
+
+			It("should write modes from tree and commit time on every entries", func() {
+				commitTime, err := strconv.ParseInt(fixture.Git("log", "-1", "--format=%ct"), 10, 64)
+				Expect(err).NotTo(HaveOccurred())
+				src := NewSource(fixture.Url(""))
+				SetCtxSymlinkMode(src, SymlinkPreserve)
+				files := zipFiles(src)
+				Expect(files["bin/run.sh"].Mode().Perm()).To(Equal(os.FileMode(0755)))
+				Expect(files["README.md"].Mode().Perm()).To(Equal(os.FileMode(0644)))
+				Expect(files["bin/"].Mode().IsDir()).To(BeTrue())
+				Expect(files["bin/link"].Mode() & os.ModeSymlink).NotTo(BeZero())
+				r, err := files["bin/link"].Open()
+				Expect(err).NotTo(HaveOccurred())
+				target, err := ioutil.ReadAll(r)
+				r.Close()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(target)).To(Equal("../README.md"))
+				for _, file := range files {
+					Expect(file.Modified).To(BeTemporally("~", time.Unix(commitTime, 0), 2*time.Second))
+				}
+			})
+			It("should honour ignore files and export-ignore attributes from the tree", func() {
+				names := make([]string, 0)
+				for name := range zipFiles(NewSource(fixture.Url(""))) {
+					names = append(names, name)
+				}
+				Expect(names).To(ContainElement("app.log"))
+				Expect(names).To(ContainElement("sub/kept.txt"))
+				Expect(names).To(ContainElement("tests/data.txt"))
+				Expect(names).NotTo(ContainElement(".zipignore"))
+				Expect(names).NotTo(ContainElement("secret.txt"))
+				Expect(names).NotTo(ContainElement("sub/local.txt"))
+				Expect(names).NotTo(ContainElement("file.md5"))
+				Expect(names).NotTo(ContainElement("docs/"))
+				Expect(names).NotTo(ContainElement("docs/index.html"))
+				Expect(names).NotTo(ContainElement("tests/fixture.txt"))
+			})
+			It("should apply attributes of repository root when zipping a sub path", func() {
+				files := zipFiles(NewSource(fixture.Url("/tests")))
+				Expect(files).To(HaveKey("data.txt"))
+				Expect(files).NotTo(HaveKey("fixture.txt"))
+			})
+			It("should apply include and exclude patterns and symlink mode", func() {
+				src := NewSource(fixture.Url(""))
+				SetCtxIncludes(src, []string{"bin/"})
+				SetCtxExcludes(src, []string{"*.sh"})
+				SetCtxSymlinkMode(src, SymlinkSkip)
+				files := zipFiles(src)
+				Expect(files).To(HaveLen(1))
+				Expect(files).To(HaveKey("bin/"))
+
+				src = NewSource(fixture.Url(""))
+				SetCtxIncludes(src, []string{"run.sh"})
+				files = zipFiles(src)
+				Expect(files).To(HaveLen(2))
+				Expect(files).To(HaveKey("bin/"))
+				Expect(files).To(HaveKey("bin/run.sh"))
+			})
+		})
+		Context("When repository needs credentials", func() {
+			var fixture *gitFixture
+			BeforeEach(func() {
+				fixture = newGitFixture()
+				fixture.Commit(map[string]string{"README.md": "readme"})
+				fixture.auth = "x-access-token:secret"
+			})
+			AfterEach(func() {
+				fixture.Close()
+			})
+			zip := func(src *Source) error {
+				SetCtxHttpClient(src, http.DefaultClient)
+				zipFile, err := handler.Zip(src)
+				if err != nil {
+					return err
+				}
+				return zipFile.Close()
+			}
+
+			It("should fail without credentials", func() {
+				Expect(zip(NewSource(fixture.Url("")))).NotTo(Succeed())
+			})
+			It("should use credentials from provider set in context", func() {
+				src := NewSource(fixture.Url(""))
+				SetCtxCredentialProvider(src, staticCredentials{"x-access-token", "secret"})
+				Expect(zip(src)).To(Succeed())
+				_, err := handler.Sha1(src)
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("should use credentials from provider set on manager", func() {
+				manager, err := NewManager(NewGitHandler())
+				Expect(err).NotTo(HaveOccurred())
+				manager.SetHttpClient(http.DefaultClient)
+				manager.SetCredentialProvider(staticCredentials{"x-access-token", "secret"})
+				session, err := manager.CreateSession(fixture.Url(""))
+				Expect(err).NotTo(HaveOccurred())
+				zipFile, err := session.Zip()
+				Expect(err).NotTo(HaveOccurred())
+				zipFile.Close()
+			})
+			It("should use token from environment for url host", func() {
+				os.Setenv("ZIPPER_GIT_TOKEN_127_0_0_1", "secret")
+				defer os.Unsetenv("ZIPPER_GIT_TOKEN_127_0_0_1")
+				src := NewSource(fixture.Url(""))
+				SetCtxPlainHttpCredentials(src, true)
+				Expect(zip(src)).To(Succeed())
+			})
+			It("should use credentials from netrc file", func() {
+				netrc := filepath.Join(filepath.Dir(fixture.work), "netrc")
+				Expect(ioutil.WriteFile(netrc, []byte("machine 127.0.0.1 login x-access-token password secret\n"), 0600)).To(Succeed())
+				os.Setenv("NETRC", netrc)
+				defer os.Unsetenv("NETRC")
+				src := NewSource(fixture.Url(""))
+				SetCtxPlainHttpCredentials(src, true)
+				Expect(zip(src)).To(Succeed())
+			})
+			It("should not send credentials from default providers to a plain http url unless allowed", func() {
+				os.Setenv("ZIPPER_GIT_TOKEN_127_0_0_1", "secret")
+				defer os.Unsetenv("ZIPPER_GIT_TOKEN_127_0_0_1")
+				Expect(zip(NewSource(fixture.Url("")))).NotTo(Succeed())
+			})
+			It("should not ask credentials when repository doesn't need them", func() {
+				fixture.auth = ""
+				provider := &countingCredentials{}
+				src := NewSource(fixture.Url(""))
+				SetCtxCredentialProvider(src, provider)
+				Expect(zip(src)).To(Succeed())
+				_, err := handler.Sha1(src)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(provider.calls).To(Equal(0))
+			})
+			It("should prefer credentials given in url", func() {
+				src := NewSource(strings.Replace(fixture.Url(""), "http://", "http://x-access-token:wrong@", 1))
+				SetCtxCredentialProvider(src, staticCredentials{"x-access-token", "secret"})
+				Expect(zip(src)).NotTo(Succeed())
+			})
+		})
+		Context("When repository is reached over ssh", func() {
+			var fixture *gitFixture
+			var server *sshGitServer
+			var commit string
+			var agentSock string
+			BeforeEach(func() {
+				fixture = newGitFixture()
+				commit = fixture.Commit(map[string]string{"README.md": "readme"})
+				server = newSshGitServer(fixture)
+				// no ssh agent is running, only given private key can be used
+				agentSock = os.Getenv("SSH_AUTH_SOCK")
+				os.Unsetenv("SSH_AUTH_SOCK")
+			})
+			AfterEach(func() {
+				if agentSock != "" {
+					os.Setenv("SSH_AUTH_SOCK", agentSock)
+				}
+				server.Close()
+				fixture.Close()
+			})
+			zip := func(options *GitSshOptions) (ZipReadCloser, error) {
+				src := NewSource(server.Url(""))
+				SetCtxHttpClient(src, http.DefaultClient)
+				SetCtxGitSshOptions(src, options)
+				return handler.Zip(src)
+			}
+
+			It("should use private key given in context and accept host key with a known fingerprint", func() {
+				zipFile, err := zip(&GitSshOptions{
+					PrivateKey:          server.ClientKey,
+					HostKeyFingerprints: []string{gossh.FingerprintSHA256(server.HostKey)},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(filesInZipByName(zipFile)).To(HaveKey("README.md"))
+
+				src := NewSource(server.Url(""))
+				SetCtxHttpClient(src, http.DefaultClient)
+				SetCtxGitSshOptions(src, &GitSshOptions{
+					PrivateKey:          server.ClientKey,
+					HostKeyFingerprints: []string{"MD5:" + gossh.FingerprintLegacyMD5(server.HostKey)},
+				})
+				sha1, err := handler.Sha1(src)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sha1).To(Equal(commit))
+			})
+			It("should refuse host key not matching given fingerprints", func() {
+				_, err := zip(&GitSshOptions{
+					PrivateKey:          server.ClientKey,
+					HostKeyFingerprints: []string{"SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"},
+				})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("doesn't match any of given fingerprints"))
+			})
+			It("should verify host key with given known hosts files", func() {
+				knownHosts := filepath.Join(filepath.Dir(fixture.work), "known_hosts")
+				Expect(ioutil.WriteFile(knownHosts, []byte(server.KnownHostsLine()), 0600)).To(Succeed())
+				zipFile, err := zip(&GitSshOptions{
+					PrivateKey:      server.ClientKey,
+					KnownHostsFiles: []string{knownHosts},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				zipFile.Close()
+
+				otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+				Expect(err).NotTo(HaveOccurred())
+				otherPublicKey, err := gossh.NewPublicKey(&otherKey.PublicKey)
+				Expect(err).NotTo(HaveOccurred())
+				line := knownhosts.Line([]string{strings.TrimPrefix(strings.TrimSuffix(server.Url(""), "/repo.git"), "ssh://git@")}, otherPublicKey)
+				Expect(ioutil.WriteFile(knownHosts, []byte(line+"\n"), 0600)).To(Succeed())
+				_, err = zip(&GitSshOptions{
+					PrivateKey:      server.ClientKey,
+					KnownHostsFiles: []string{knownHosts},
+				})
+				Expect(err).To(HaveOccurred())
+			})
+			It("should authenticate on git lfs api with git-lfs-authenticate over ssh", func() {
+				fixture.Commit(map[string]string{"assets/big.bin": fixture.LfsPointer("large content")})
+				server.LfsHref = fixture.Url("/info/lfs")
+				src := NewSource(server.Url("?lfs=true"))
+				SetCtxHttpClient(src, http.DefaultClient)
+				SetCtxGitSshOptions(src, &GitSshOptions{PrivateKey: server.ClientKey, Insecure: true})
+				zipFile, err := handler.Zip(src)
+				Expect(err).NotTo(HaveOccurred())
+				file, ok := filesInZipByName(zipFile)["assets/big.bin"]
+				Expect(ok).To(BeTrue())
+				r, err := file.Open()
+				Expect(err).NotTo(HaveOccurred())
+				defer r.Close()
+				b, err := ioutil.ReadAll(r)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(b)).To(Equal("large content"))
+				Expect(fixture.lfsAuth).To(Equal("RemoteAuth ssh-token"))
+				Expect(server.LfsPath).To(Equal("'/repo.git'"))
+			})
+			It("should fail with a clear error when git-lfs-authenticate can't be run over ssh", func() {
+				fixture.Commit(map[string]string{"assets/big.bin": fixture.LfsPointer("large content")})
+				src := NewSource(server.Url("?lfs=true"))
+				SetCtxHttpClient(src, http.DefaultClient)
+				SetCtxGitSshOptions(src, &GitSshOptions{PrivateKey: server.ClientKey, Insecure: true})
+				_, err := handler.Zip(src)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("Git lfs authentication over ssh"))
+				Expect(fixture.lfsAuth).To(BeEmpty())
+			})
+			It("should accept any host key in insecure mode", func() {
+				zipFile, err := zip(&GitSshOptions{
+					PrivateKey: server.ClientKey,
+					Insecure:   true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				zipFile.Close()
+			})
+			It("should give ssh agent error when there is no agent and no private key", func() {
+				_, err := zip(&GitSshOptions{Insecure: true})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("SSH agent"))
+
+				src := NewSource(server.Url(""))
+				SetCtxHttpClient(src, http.DefaultClient)
+				SetCtxGitSshOptions(src, &GitSshOptions{KnownHostsFiles: []string{"/dev/null"}})
+				_, err = handler.Sha1(src)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("SSH agent"))
+			})
+			It("should use options set on manager", func() {
+				manager, err := NewManager(NewGitHandler())
+				Expect(err).NotTo(HaveOccurred())
+				manager.SetGitSshOptions(&GitSshOptions{
+					PrivateKey: server.ClientKey,
+					Insecure:   true,
+				})
+				session, err := manager.CreateSession(server.Url(""))
+				Expect(err).NotTo(HaveOccurred())
+				zipFile, err := session.Zip()
+				Expect(err).NotTo(HaveOccurred())
+				zipFile.Close()
+			})
+		})
+		Context("When repository uses export attributes", func() {
+			var fixture *gitFixture
+			BeforeEach(func() {
+				fixture = newGitFixture()
+				Expect(os.MkdirAll(filepath.Join(fixture.work, "bin"), 0755)).To(Succeed())
+				Expect(os.Symlink("../README.md", filepath.Join(fixture.work, "bin", "link"))).To(Succeed())
+				fixture.Commit(map[string]string{
+					"README.md":  "readme $Format:%H$",
+					"bin/run.sh": "#!/bin/sh",
+					".gitattributes": "*.md5 export-ignore\n/docs export-ignore\n[attr]no-export export-ignore\n" +
+						"VERSION export-subst\nsrc/*.go export-subst\n",
+					"file.md5":             "md5",
+					"docs/index.html":      "docs",
+					"tests/.gitattributes": "*.txt export-ignore\nfixture.txt no-export\ndata.txt -export-ignore\n",
+					"tests/fixture.txt":    "fixture",
+					"tests/data.txt":       "data",
+					"tests/other.txt":      "other",
+					"src/main.go":          "package main // $Format:%h$",
+				})
+				fixture.Git("update-index", "--chmod=+x", "bin/run.sh")
+				Expect(ioutil.WriteFile(filepath.Join(fixture.work, "VERSION"), []byte(
+					"$Format:%H %h %T %t %P %p$\n"+
+						"$Format:%an <%ae> %al %ad|%aD|%ai|%aI|%as|%at$\n"+
+						"$Format:%cn %ce %cl %cs %ct$\n"+
+						"$Format:%s|%b|%B$\n"+
+						"$Format:%%%n%x41%Z%$ and $Format:unterminated\n",
+				), 0644)).To(Succeed())
+				fixture.Git("add", "-A")
+				fixture.Git("commit", "-q", "-m", "subject on\ntwo lines", "-m", "body text", "--date", "2020-02-03T04:05:06+0530")
+				fixture.Push()
+			})
+			AfterEach(func() {
+				fixture.Close()
+			})
+			// content of every entries of a zip with the executable bit of files
+			zipContent := func(zipReader *zip.Reader) map[string]string {
+				content := make(map[string]string)
+				for _, file := range zipReader.File {
+					r, err := file.Open()
+					Expect(err).NotTo(HaveOccurred())
+					b, err := ioutil.ReadAll(r)
+					r.Close()
+					Expect(err).NotTo(HaveOccurred())
+					content[file.Name] = fmt.Sprintf("%t %t %s", file.Mode()&0100 != 0, file.Mode()&os.ModeSymlink != 0, b)
+				}
+				return content
+			}
+			gitArchiveContent := func() map[string]string {
+				archive := filepath.Join(filepath.Dir(fixture.work), "archive.zip")
+				fixture.Git("archive", "--format=zip", "-o", archive, "HEAD")
+				zipReader, err := zip.OpenReader(archive)
+				Expect(err).NotTo(HaveOccurred())
+				defer zipReader.Close()
+				return zipContent(&zipReader.Reader)
+			}
+			zipperContent := func(src *Source) map[string]string {
+				SetCtxHttpClient(src, http.DefaultClient)
+				SetCtxSymlinkMode(src, SymlinkPreserve)
+				zipFile, err := handler.Zip(src)
+				Expect(err).NotTo(HaveOccurred())
+				defer zipFile.Close()
+				b, err := ioutil.ReadAll(zipFile)
+				Expect(err).NotTo(HaveOccurred())
+				zipReader, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+				Expect(err).NotTo(HaveOccurred())
+				return zipContent(zipReader)
+			}
+
+			It("should create the same files as git archive", func() {
+				expected := gitArchiveContent()
+				Expect(expected).To(HaveKey("VERSION"))
+				Expect(expected).NotTo(HaveKey("tests/fixture.txt"))
+				Expect(zipperContent(NewSource(fixture.Url("")))).To(Equal(expected))
+			})
+			It("should create the same files as git archive when a working tree is used", func() {
+				src := NewSource(fixture.Url(""))
+				SetCtxGitSubmodules(src, true)
+				Expect(zipperContent(src)).To(Equal(gitArchiveContent()))
+			})
+		})
+		Context("When it contains sub path of a local repository", func() {
+			var fixture *gitFixture
+			var commitSha1 string
+			BeforeEach(func() {
+				fixture = newGitFixture()
+				commitSha1 = fixture.Commit(map[string]string{
+					"README.md":              "readme",
+					"other/file.txt":         "other",
+					"apps/app1/main.go":      "package main",
+					"apps/app1/lib/lib.go":   "package lib",
+					"apps/app1/assets/a.bin": fixture.LfsPointer("app1 content"),
+					"apps/app2/main.go":      "package main",
+				})
+			})
+			AfterEach(func() {
+				fixture.Close()
+			})
+			zip := func(src *Source) []string {
+				SetCtxHttpClient(src, http.DefaultClient)
+				zipFile, err := handler.Zip(src)
+				Expect(err).NotTo(HaveOccurred())
+				return namesInZip(zipFile)
+			}
+
+			It("should write only files of sub path on disk", func() {
+				tmpDir, err := ioutil.TempDir("", "git-sparse")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(tmpDir)
+				gitUtils := GitUtils{
+					Folder:  tmpDir,
+					Url:     fixture.Url(""),
+					RefName: "HEAD",
+					SubPath: "/apps/app1",
+				}
+				Expect(gitUtils.Clone()).To(Succeed())
+				Expect(filepath.Join(tmpDir, "apps", "app1", "lib", "lib.go")).To(BeAnExistingFile())
+				Expect(filepath.Join(tmpDir, "README.md")).NotTo(BeAnExistingFile())
+				Expect(filepath.Join(tmpDir, "other")).NotTo(BeAnExistingFile())
+				Expect(filepath.Join(tmpDir, "apps", "app2")).NotTo(BeAnExistingFile())
+
+				commitTime, err := gitUtils.CommitTime()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(commitTime.IsZero()).To(BeFalse())
+			})
+			It("should create zip file with only files of sub path", func() {
+				names := zip(NewSource(fixture.Url("/apps/app1")))
+				Expect(names).To(ConsistOf("main.go", "lib/", "lib/lib.go", "assets/", "assets/a.bin"))
+			})
+			It("should create zip file of sub path from a commit and using a cache", func() {
+				cacheDir, err := ioutil.TempDir("", "git-cache")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(cacheDir)
+				fixture.Commit(map[string]string{"apps/app1/later.go": "package main"})
+				src := NewSource(fixture.Url("/apps/app1#" + commitSha1))
+				SetCtxGitCacheDir(src, cacheDir)
+				Expect(zip(src)).To(ConsistOf("main.go", "lib/", "lib/lib.go", "assets/", "assets/a.bin"))
+			})
+			It("should only download git lfs files of sub path", func() {
+				fixture.Commit(map[string]string{"apps/app2/b.bin": fixture.LfsPointer("app2 content")})
+				// content of app2 file is not served, only app1 file must be requested
+				for oid, content := range fixture.lfsObjects {
+					if string(content) == "app2 content" {
+						delete(fixture.lfsObjects, oid)
+					}
+				}
+				src := NewSource(fixture.Url("/apps/app1?lfs=true"))
+				Expect(zip(src)).To(ContainElement("assets/a.bin"))
+			})
+			It("should return an error when sub path is not a folder of repository", func() {
+				for _, subPath := range []string{"/apps/app3", "/README.md"} {
+					src := NewSource(fixture.Url(subPath))
+					SetCtxHttpClient(src, http.DefaultClient)
+					_, err := handler.Zip(src)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("is not a folder in repository"))
+				}
+			})
+		})
 		Context("When it contains sub path", func() {
 			var previousZipFiles []string
 			BeforeEach(func() {
@@ -2,16 +2,21 @@ package zipper
 
 import (
 	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"mime"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
 )
 
 type HttpHandler struct {
@@ -19,23 +24,9 @@ type HttpHandler struct {
 
 func (h HttpHandler) Zip(src *Source) (ZipReadCloser, error) {
 	processor := NewCompressProcessor(src, h.readCloserFunc)
-	zipProc, err := processor.ToZip()
-	if err != nil {
-		return nil, err
-	}
-	if zipProc != nil {
-		return zipProc, nil
-	}
-	resp, err := h.doRequest(src)
-	if err != nil {
-		return nil, err
-	}
-	err = h.checkRespHttpError(resp)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	return h.createZipFile(resp, src)
+	return processor.ToZipOr(func(reader io.ReadCloser, size int64, _ string) (ZipReadCloser, error) {
+		return h.createZipFile(reader, size, src)
+	})
 }
 
 func (h HttpHandler) readCloserFunc(src *Source) (io.ReadCloser, int64, string, error) {
@@ -55,7 +46,8 @@ func (h HttpHandler) readCloserFunc(src *Source) (io.ReadCloser, int64, string,
 			path = params["filename"]
 		}
 	}
-	return resp.Body, resp.ContentLength, path, nil
+	lastModified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return modTimeReadCloser{resp.Body, lastModified}, resp.ContentLength, path, nil
 }
 
 func (h HttpHandler) checkRespHttpError(resp *http.Response) error {
@@ -76,85 +68,94 @@ func (h HttpHandler) checkRespHttpError(resp *http.Response) error {
 	)
 }
 
-func (h HttpHandler) isExecutable(src *Source) bool {
-	resp, err := h.doRequest(src)
-	if err != nil {
-		return false
-	}
-
-	err = h.checkRespHttpError(resp)
-	if err != nil {
-		return false
-	}
-	return IsExecutable(resp.Body)
-}
-
-func (h HttpHandler) createZipFile(resp *http.Response, src *Source) (ZipReadCloser, error) {
-	zipFile, err := ioutil.TempFile("", "downloads-zipper")
-	if err != nil {
-		return nil, err
-	}
-	cleanFunc := func() error {
-		return os.Remove(zipFile.Name())
-	}
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
-
-	size := resp.ContentLength
+func (h HttpHandler) createZipFile(reader io.ReadCloser, size int64, src *Source) (ZipReadCloser, error) {
+	br := bufio.NewReader(reader)
+	header, _ := br.Peek(4)
 	fh := &zip.FileHeader{
-		Name:               filepath.Base(src.Path),
-		UncompressedSize64: uint64(size),
+		Name: filepath.Base(src.Path),
 	}
 	fh.SetModTime(time.Now())
-	if h.isExecutable(src) {
+	if IsExecutable(bytes.NewReader(header)) {
 		fh.SetMode(0755)
 	} else {
 		fh.SetMode(0644)
 	}
 
+	if size != UnknownSize {
+		fh.UncompressedSize64 = uint64(size)
+	}
 	if fh.UncompressedSize64 > ((1 << 32) - 1) {
 		fh.UncompressedSize = (1 << 32) - 1
 	} else {
 		fh.UncompressedSize = uint32(fh.UncompressedSize64)
 	}
-	w, err := zipWriter.CreateHeader(fh)
+	return makeArchiveFile(src, "downloads-zipper", func(zipWriter EntryWriter) error {
+		w, err := zipWriter.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, br)
+		return err
+	}, reader.Close)
+}
+
+func (h HttpHandler) newRequest(src *Source, method string) (*http.Request, error) {
+	u, err := url.Parse(src.Path)
 	if err != nil {
 		return nil, err
 	}
-
-	_, err = io.Copy(w, resp.Body)
+	username := ""
+	password := ""
+	if u.User != nil && u.User.Username() != "" {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	u.User = nil
+	req, err := http.NewRequest(method, u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-	zipWriter.Close()
-	zipFile.Close()
+	req = req.WithContext(src.Context())
+	if username != "" || password != "" {
+		req.SetBasicAuth(username, password)
+	}
+	return req, nil
+}
 
-	file, err := os.Open(zipFile.Name())
+func (h HttpHandler) doRequest(src *Source) (*http.Response, error) {
+	req, err := h.newRequest(src, "GET")
 	if err != nil {
 		return nil, err
 	}
-	fs, _ := file.Stat()
-	return NewZipFile(file, fs.Size(), cleanFunc), nil
+	return h.send(src, req)
 }
 
-func (h HttpHandler) doRequest(src *Source) (*http.Response, error) {
+// Send request, when server asks for authentication and request has no credentials,
+// request is sent again with credentials found for the url which has been challenged (see sourceCredentials)
+func (h HttpHandler) send(src *Source, req *http.Request) (*http.Response, error) {
 	client := CtxHttpClient(src)
-	u, _ := url.Parse(src.Path)
-	username := ""
-	password := ""
-	if u.User != nil && u.User.Username() != "" {
-		username = u.User.Username()
-		password, _ = u.User.Password()
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
 	}
-	u.User = nil
-	req, err := http.NewRequest("GET", u.String(), nil)
+	if _, _, ok := req.BasicAuth(); ok {
+		return resp, nil
+	}
+	challenged := resp.Request.URL
+	creds, err := sourceCredentials(src, challenged)
 	if err != nil {
+		resp.Body.Close()
 		return nil, err
 	}
-	if username != "" {
-		req.SetBasicAuth(username, password)
+	if creds == nil {
+		return resp, nil
 	}
-	return client.Do(req)
+	resp.Body.Close()
+	retry := req.Clone(req.Context())
+	retry.URL = challenged
+	retry.Host = ""
+	retry.SetBasicAuth(creds.Username, creds.Password)
+	return client.Do(retry)
 }
 
 func (h HttpHandler) Detect(src *Source) bool {
@@ -162,14 +163,17 @@ func (h HttpHandler) Detect(src *Source) bool {
 	return IsWebURL(path)
 }
 
+// Create signature from ETag, Last-Modified and Content-Length headers given by a HEAD request
+// When server doesn't give ETag or Last-Modified header, signature is made from the first 5kb of the remote file
 func (h HttpHandler) Sha1(src *Source) (string, error) {
-	client := CtxHttpClient(src)
-	path := src.Path
-	req, err := http.NewRequest("GET", path, nil)
-	if err != nil {
-		return "", err
+	sha1, err := h.MetadataSha1(src)
+	if err == nil {
+		return sha1, nil
 	}
-	resp, err := client.Do(req)
+	if src.Context().Err() != nil {
+		return "", src.Context().Err()
+	}
+	resp, err := h.doRequest(src)
 	if err != nil {
 		return "", err
 	}
@@ -181,6 +185,129 @@ func (h HttpHandler) Sha1(src *Source) (string, error) {
 	return GetSha1FromReader(resp.Body)
 }
 
+// Create signature from ETag, Last-Modified and Content-Length headers given by a HEAD request
+// This not download the file
+// Headers are kept in memory with signature as key to check diff later with a conditional request (see HttpHandler.IsDiff)
+func (h HttpHandler) MetadataSha1(src *Source) (string, error) {
+	req, err := h.newRequest(src, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	resp, err := h.send(src, req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	err = h.checkRespHttpError(resp)
+	if err != nil {
+		return "", err
+	}
+	validators := newHttpValidators(resp)
+	if validators.ETag == "" && validators.LastModified == "" {
+		return "", fmt.Errorf("Server doesn't give ETag or Last-Modified header for '%s'", src.Path)
+	}
+	return knownHttpValidators.store(validators), nil
+}
+
+// Check if remote file changed from a signature with a conditional HEAD request (If-None-Match / If-Modified-Since)
+// This can only be done when signature has been made from ETag or Last-Modified by this process, otherwise handled is false
+func (h HttpHandler) IsDiff(src *Source, storedSha1 string) (bool, string, bool, error) {
+	validators, ok := knownHttpValidators.load(storedSha1)
+	if !ok {
+		return false, "", false, nil
+	}
+	req, err := h.newRequest(src, "HEAD")
+	if err != nil {
+		return true, "", true, err
+	}
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+	resp, err := h.send(src, req)
+	if err != nil {
+		return true, "", true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return false, storedSha1, true, nil
+	}
+	err = h.checkRespHttpError(resp)
+	if err != nil {
+		return true, "", true, err
+	}
+	newValidators := newHttpValidators(resp)
+	if newValidators.ETag == "" && newValidators.LastModified == "" {
+		return false, "", false, nil
+	}
+	newSha1 := knownHttpValidators.store(newValidators)
+	return newSha1 != storedSha1, newSha1, true, nil
+}
+
+func (h HttpHandler) SignatureStrategies() []string {
+	return []string{SignatureFast, SignatureFull, SignatureMetadata}
+}
+
 func (h HttpHandler) Name() string {
 	return "http"
 }
+
+// validators given by a server for a file, they are used for conditional requests
+type httpValidators struct {
+	ETag          string
+	LastModified  string
+	ContentLength string
+}
+
+func newHttpValidators(resp *http.Response) httpValidators {
+	v := httpValidators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if resp.ContentLength >= 0 {
+		v.ContentLength = strconv.FormatInt(resp.ContentLength, 10)
+	}
+	return v
+}
+
+func (v httpValidators) String() string {
+	return fmt.Sprintf("%s\n%s\n%s\n", v.ETag, v.LastModified, v.ContentLength)
+}
+
+func (v httpValidators) sha1() string {
+	hash := sha1.Sum([]byte(v.String()))
+	return hex.EncodeToString(hash[:])
+}
+
+// Max number of validators kept by httpValidatorsLookup, every validators are forgotten when it's reached
+const maxKnownHttpValidators = 1024
+
+// Validators of signatures made by this process, looked up by signature to make conditional requests
+var knownHttpValidators = &httpValidatorsLookup{}
+
+type httpValidatorsLookup struct {
+	mutex      sync.Mutex
+	validators map[string]httpValidators
+}
+
+// Keep validators and give their signature
+func (l *httpValidatorsLookup) store(v httpValidators) string {
+	sha1 := v.sha1()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.validators == nil || len(l.validators) >= maxKnownHttpValidators {
+		l.validators = make(map[string]httpValidators)
+	}
+	l.validators[sha1] = v
+	return sha1
+}
+
+// Retrieve validators of a signature, ok is false when signature has not been made by this process
+func (l *httpValidatorsLookup) load(sha1 string) (httpValidators, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	v, ok := l.validators[sha1]
+	return v, ok
+}
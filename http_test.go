@@ -3,15 +3,18 @@ package zipper_test
 import (
 	. "github.com/ArthurHlt/zipper"
 
+	"context"
 	"encoding/base64"
 	"fmt"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -22,6 +25,8 @@ const (
 type ServeFileTestHandler struct {
 	files map[string]string
 	check func(req *http.Request)
+	// basic auth, as user:password, required by server when set
+	auth string
 }
 
 func createUrl(s *httptest.Server, path string) string {
@@ -42,6 +47,14 @@ func (h ServeFileTestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request
 	if h.check != nil {
 		h.check(req)
 	}
+	if h.auth != "" {
+		user, password, _ := req.BasicAuth()
+		if user+":"+password != h.auth {
+			w.Header().Set("WWW-Authenticate", `Basic realm="zipper"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
 	f, err := os.Open(h.files[path])
 	if err != nil {
 		panic(err)
@@ -55,6 +68,16 @@ func (h ServeFileTestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request
 	}
 }
 
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
 var _ = Describe("Http", func() {
 	var handler HttpHandler
 	var server *httptest.Server
@@ -75,6 +98,7 @@ var _ = Describe("Http", func() {
 			"/final.tar":    filepath.Join(workingDir, "fixtures", "applications", "final.tar"),
 			"/text":         filepath.Join(workingDir, "fixtures", "applications", "text"),
 			"/executable":   filepath.Join(workingDir, "fixtures", "applications", "executable"),
+			"/tgz":          filepath.Join(workingDir, "fixtures", "applications", "final.tar.gz"),
 		}}
 		server = httptest.NewServer(servHandler)
 		httpClient = server.Client()
@@ -93,7 +117,132 @@ var _ = Describe("Http", func() {
 			Expect(sha1).Should(Equal("a93ecf13274b289469dee7a0b9e910bc7d2990ce"))
 		})
 	})
+	Describe("MetadataSha1", func() {
+		It("should return an error when server doesn't give ETag or Last-Modified header", func() {
+			src := NewSource(createUrl(server, "/final.zip"))
+			SetCtxHttpClient(src, httpClient)
+			_, err := handler.MetadataSha1(src)
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("when server gives ETag and Last-Modified headers", func() {
+		var etagServer *httptest.Server
+		var etag string
+		var methods []string
+		var statuses []int
+		BeforeEach(func() {
+			etag = `"v1"`
+			methods = make([]string, 0)
+			statuses = make([]int, 0)
+			lastModified := time.Date(2019, 8, 9, 0, 0, 0, 0, time.UTC)
+			etagServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				methods = append(methods, req.Method)
+				w.Header().Set("ETag", etag)
+				rw := &statusRecorder{ResponseWriter: w}
+				http.ServeContent(rw, req, "file", lastModified, strings.NewReader("content"))
+				statuses = append(statuses, rw.status)
+			}))
+		})
+		AfterEach(func() {
+			etagServer.Close()
+		})
+		newSource := func() *Source {
+			src := NewSource(createUrl(etagServer, "/file"))
+			SetCtxHttpClient(src, etagServer.Client())
+			return src
+		}
+		It("should create sha1 from headers given by a HEAD request", func() {
+			sha1, err := handler.Sha1(newSource())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sha1).To(MatchRegexp("^[0-9a-f]{40}$"))
+			Expect(methods).To(Equal([]string{"HEAD"}))
+
+			etag = `"v2"`
+			newSha1, err := handler.Sha1(newSource())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newSha1).ToNot(Equal(sha1))
+		})
+		It("should check diff with a conditional request answered by 304 when remote file doesn't change", func() {
+			session := NewSession(newSource(), handler)
+			sha1, err := session.Sha1()
+			Expect(err).NotTo(HaveOccurred())
+
+			diff, newSha1, err := session.IsDiff(sha1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diff).To(BeFalse())
+			Expect(newSha1).To(Equal(sha1))
+			Expect(methods).To(Equal([]string{"HEAD", "HEAD"}))
+			Expect(statuses).To(Equal([]int{http.StatusOK, http.StatusNotModified}))
+		})
+		It("should check diff with a conditional request from a signature stored previously", func() {
+			sha1, err := NewSession(newSource(), handler).Sha1()
+			Expect(err).NotTo(HaveOccurred())
+
+			diff, newSha1, err := NewSession(newSource(), HttpHandler{}).IsDiff(sha1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diff).To(BeFalse())
+			Expect(newSha1).To(Equal(sha1))
+			Expect(statuses).To(Equal([]int{http.StatusOK, http.StatusNotModified}))
+		})
+		It("should check diff without conditional request from a signature not made by this process", func() {
+			session := NewSession(newSource(), handler)
+			sha1, err := session.Sha1()
+			Expect(err).NotTo(HaveOccurred())
+
+			diff, newSha1, err := session.IsDiff("0000000000000000000000000000000000000000")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diff).To(BeTrue())
+			Expect(newSha1).To(Equal(sha1))
+			Expect(methods).To(Equal([]string{"HEAD", "HEAD"}))
+			Expect(statuses).To(Equal([]int{http.StatusOK, http.StatusOK}))
+		})
+		It("should find diff when remote file changed", func() {
+			session := NewSession(newSource(), handler)
+			sha1, err := session.Sha1()
+			Expect(err).NotTo(HaveOccurred())
+
+			etag = `"v2"`
+			diff, newSha1, err := session.IsDiff(sha1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diff).To(BeTrue())
+			Expect(newSha1).ToNot(Equal(sha1))
+			Expect(methods).To(Equal([]string{"HEAD", "HEAD"}))
+		})
+	})
 	Describe("Zip", func() {
+		Context("when counting requests", func() {
+			var nbRequests int
+			BeforeEach(func() {
+				nbRequests = 0
+				servHandler.check = func(req *http.Request) {
+					nbRequests++
+				}
+			})
+			for _, path := range []string{"/final.zip", "/final.tar", "/final.tar.gz", "/tgz", "/executable"} {
+				path := path
+				It("should download "+path+" only once", func() {
+					src := NewSource(createUrl(server, path))
+					SetCtxHttpClient(src, httpClient)
+					zipFile, err := handler.Zip(src)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err = io.Copy(ioutil.Discard, zipFile)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(zipFile.Close()).To(Succeed())
+					Expect(nbRequests).To(Equal(1))
+				})
+			}
+			It("should detect a tgz file without extension from its content", func() {
+				src := NewSource(createUrl(server, "/tgz"))
+				SetCtxHttpClient(src, httpClient)
+				zipFile, err := handler.Zip(src)
+				Expect(err).NotTo(HaveOccurred())
+				defer zipFile.Close()
+
+				checkZipFile(zipFile)
+			})
+		})
 		Context("with basic auth", func() {
 			It("use basic auth in request", func() {
 				ran := false
@@ -120,6 +269,51 @@ var _ = Describe("Http", func() {
 
 				Expect(ran).To(BeTrue())
 			})
+			It("use credentials from provider when server asks for authentication and url doesn't contain any", func() {
+				servHandler.auth = "user:secret"
+				auths := make([]bool, 0)
+				servHandler.check = func(req *http.Request) {
+					_, _, ok := req.BasicAuth()
+					auths = append(auths, ok)
+				}
+				src := NewSource(createUrl(server, "/final.zip"))
+				SetCtxHttpClient(src, httpClient)
+				SetCtxCredentialProvider(src, staticCredentials{"user", "secret"})
+
+				zipFile, err := handler.Zip(src)
+				Expect(err).NotTo(HaveOccurred())
+				defer zipFile.Close()
+
+				Expect(auths).To(Equal([]bool{false, true}))
+			})
+			It("doesn't ask credentials when server doesn't ask for authentication", func() {
+				provider := &countingCredentials{}
+				src := NewSource(createUrl(server, "/final.zip"))
+				SetCtxHttpClient(src, httpClient)
+				SetCtxCredentialProvider(src, provider)
+
+				zipFile, err := handler.Zip(src)
+				Expect(err).NotTo(HaveOccurred())
+				defer zipFile.Close()
+
+				Expect(provider.calls).To(Equal(0))
+			})
+			It("doesn't send credentials from default providers to a plain http url unless allowed", func() {
+				servHandler.auth = "x-access-token:secret"
+				os.Setenv("ZIPPER_GIT_TOKEN_127_0_0_1", "secret")
+				defer os.Unsetenv("ZIPPER_GIT_TOKEN_127_0_0_1")
+				src := NewSource(createUrl(server, "/final.zip"))
+				SetCtxHttpClient(src, httpClient)
+
+				_, err := handler.Zip(src)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("401"))
+
+				SetCtxPlainHttpCredentials(src, true)
+				zipFile, err := handler.Zip(src)
+				Expect(err).NotTo(HaveOccurred())
+				zipFile.Close()
+			})
 		})
 		It("should create zip file from a zip source url", func() {
 			src := NewSource(createUrl(server, "/final.zip"))
@@ -139,6 +333,17 @@ var _ = Describe("Http", func() {
 
 			checkZipFile(zipFile)
 		})
+		It("should stream zip file from a tgz source url when streaming is set", func() {
+			src := NewSource(createUrl(server, "/final.tar.gz"))
+			SetCtxHttpClient(src, httpClient)
+			SetCtxStreaming(src, true)
+			zipFile, err := handler.Zip(src)
+			Expect(err).NotTo(HaveOccurred())
+			defer zipFile.Close()
+
+			Expect(zipFile.Size()).To(Equal(UnknownSize))
+			checkZipFile(zipFile)
+		})
 		It("should create zip file from a tar source url", func() {
 			src := NewSource(createUrl(server, "/final.tar"))
 			SetCtxHttpClient(src, httpClient)
@@ -183,6 +388,40 @@ var _ = Describe("Http", func() {
 			})
 		})
 	})
+	Context("when context is cancelled during download", func() {
+		var slowServer *httptest.Server
+		BeforeEach(func() {
+			slowServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(200)
+				w.Write(make([]byte, 1024))
+				w.(http.Flusher).Flush()
+				<-req.Context().Done()
+			}))
+		})
+		AfterEach(func() {
+			slowServer.Close()
+		})
+		It("should abort zip creation", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			src := NewSource(createUrl(slowServer, "/file")).WithContext(ctx)
+			SetCtxHttpClient(src, slowServer.Client())
+			time.AfterFunc(200*time.Millisecond, cancel)
+
+			_, err := handler.Zip(src)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(context.Canceled.Error()))
+		})
+		It("should abort sha1 retrieving", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			src := NewSource(createUrl(slowServer, "/file")).WithContext(ctx)
+			SetCtxHttpClient(src, slowServer.Client())
+			cancel()
+
+			_, err := handler.Sha1(src)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(context.Canceled.Error()))
+		})
+	})
 	Describe("Detect", func() {
 		It("should return true when an http(s) link and extension one of on zip, jar, war, tar or tgz file", func() {
 			Expect(handler.Detect(NewSource("http://foo.com/app.zip"))).Should(BeTrue(), "zip")
@@ -5,16 +5,21 @@ import (
 	"bufio"
 	"bytes"
 	"code.cloudfoundry.org/gofileutils/fileutils"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"github.com/ArthurHlt/zipper/dirfiles"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
 )
 
 type LocalHandler struct {
+	// When true, signature is a digest made from relative path, mode and sha1 of every non ignored files
+	// instead of the first 5kb of the zip (any content change is detected and no zip is created)
+	ContentHash bool
 }
 
 func (h LocalHandler) Zip(src *Source) (ZipReadCloser, error) {
@@ -33,7 +38,7 @@ func (h LocalHandler) Zip(src *Source) (ZipReadCloser, error) {
 				file.Close()
 				return nil, 0, "", err
 			}
-			return file, stat.Size(), src.Path, nil
+			return modTimeReadCloser{readCloser{NewContextReader(src.Context(), file), file}, stat.ModTime()}, stat.Size(), src.Path, nil
 		})
 		zipProc, err := processor.ToZip()
 		if err != nil {
@@ -44,24 +49,15 @@ func (h LocalHandler) Zip(src *Source) (ZipReadCloser, error) {
 		}
 	}
 	path := src.Path
-	zipFile, err := ioutil.TempFile("", "uploads-zipper")
+	err = h.checkDirNotEmpty(path)
 	if err != nil {
 		return nil, err
 	}
-	err = h.ZipFiles(path, zipFile)
-	if err != nil {
-		return nil, err
-	}
-	file, err := os.Open(zipFile.Name())
-	if err != nil {
-		return nil, err
-	}
-	cleanFunc := func() error {
-		return os.Remove(zipFile.Name())
-	}
-	fs, _ := file.Stat()
-	return NewZipFile(file, fs.Size(), cleanFunc), nil
+	return makeArchiveFile(src, "uploads-zipper", func(w EntryWriter) error {
+		return h.writeEntries(src, path, w)
+	}, nil)
 }
+
 func (h LocalHandler) Detect(src *Source) bool {
 	path := src.Path
 	if _, err := os.Stat(path); os.IsNotExist(err) {
@@ -70,6 +66,9 @@ func (h LocalHandler) Detect(src *Source) bool {
 	return true
 }
 func (h LocalHandler) Sha1(src *Source) (string, error) {
+	if h.ContentHash {
+		return h.ContentSha1(src)
+	}
 	zipFile, err := h.Zip(src)
 	if err != nil {
 		return "", err
@@ -78,12 +77,43 @@ func (h LocalHandler) Sha1(src *Source) (string, error) {
 	return GetSha1FromReader(zipFile)
 }
 
+// Create signature from relative path, mode and sha1 of every non ignored files in source directory
+// (or from the full content when source is a file)
+func (h LocalHandler) ContentSha1(src *Source) (string, error) {
+	stat, err := os.Stat(src.Path)
+	if err != nil {
+		return "", err
+	}
+	if stat.IsDir() {
+		return h.dirFiles(src).ContentSha1Context(src.Context(), src.Path)
+	}
+	file, err := os.Open(src.Path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha1.New()
+	_, err = io.Copy(hash, NewContextReader(src.Context(), file))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (h LocalHandler) SignatureStrategies() []string {
+	return []string{SignatureFast, SignatureFull, SignatureContent}
+}
+
 func (h LocalHandler) Name() string {
 	return "local"
 }
 
 func (h LocalHandler) ZipFiles(dirOrZipFilePath string, targetFile *os.File) error {
-	err := h.writeZipFile(dirOrZipFilePath, targetFile)
+	err := h.checkDirNotEmpty(dirOrZipFilePath)
+	if err != nil {
+		return err
+	}
+	err = h.writeZipFile(NewSource(dirOrZipFilePath), dirOrZipFilePath, targetFile)
 	if err != nil {
 		return err
 	}
@@ -109,7 +139,7 @@ func (h LocalHandler) GetZipSize(zipFile *os.File) (int64, error) {
 	return zipFileSize, nil
 }
 
-func (h LocalHandler) writeZipFile(dir string, targetFile *os.File) error {
+func (h LocalHandler) checkDirNotEmpty(dir string) error {
 	isEmpty, err := fileutils.IsDirEmpty(dir)
 	if err != nil {
 		return err
@@ -118,55 +148,88 @@ func (h LocalHandler) writeZipFile(dir string, targetFile *os.File) error {
 	if isEmpty {
 		return fmt.Errorf("%s is empty", dir)
 	}
+	return nil
+}
 
+func (h LocalHandler) dirFiles(src *Source) dirfiles.DirFiles {
+	return dirfiles.DirFiles{
+		Symlinks:  CtxSymlinkMode(src),
+		GitIgnore: CtxGitIgnore(src),
+		Filter:    CtxPathFilter(src),
+	}
+}
+
+func (h LocalHandler) writeZipFile(src *Source, dir string, targetFile io.Writer) error {
 	writer := zip.NewWriter(targetFile)
 	defer writer.Close()
 
-	appfiles := dirfiles.DirFiles{}
-	return appfiles.WalkAppFiles(dir, func(fileName string, fullPath string) error {
-		fileInfo, err := os.Stat(fullPath)
-		if err != nil {
-			return err
-		}
+	err := h.writeEntries(src, dir, writer)
+	if err != nil {
+		return err
+	}
+	return writer.Close()
+}
 
-		header, err := zip.FileInfoHeader(fileInfo)
-		if err != nil {
-			return err
-		}
+func (h LocalHandler) writeEntries(src *Source, dir string, writer EntryWriter) error {
+	ctx := src.Context()
+	appfiles := h.dirFiles(src)
+	return appfiles.WalkAppFilesContext(ctx, dir, func(fileName string, fullPath string) error {
+		return writeWalkedFile(ctx, writer, fileName, fullPath)
+	})
+}
 
-		if runtime.GOOS == "windows" {
-			header.SetMode(header.Mode() | 0700)
-		}
+// write a file given by dirfiles walk in archive, symlinks are written with their target as content
+func writeWalkedFile(ctx context.Context, writer EntryWriter, fileName string, fullPath string) error {
+	fileInfo, err := os.Lstat(fullPath)
+	if err != nil {
+		return err
+	}
 
-		header.Name = filepath.ToSlash(fileName)
-		header.Method = zip.Deflate
+	header, err := zip.FileInfoHeader(fileInfo)
+	if err != nil {
+		return err
+	}
 
-		if fileInfo.IsDir() {
-			header.Name += "/"
-		}
+	if runtime.GOOS == "windows" {
+		header.SetMode(header.Mode() | 0700)
+	}
 
-		zipFilePart, err := writer.CreateHeader(header)
-		if err != nil {
-			return err
-		}
+	header.Name = filepath.ToSlash(fileName)
+	header.Method = zip.Deflate
+	if fileInfo.Mode()&os.ModeSymlink != 0 {
+		header.Method = zip.Store
+	}
 
-		if fileInfo.IsDir() {
-			return nil
-		}
+	if fileInfo.IsDir() {
+		header.Name += "/"
+	}
 
-		file, err := os.Open(fullPath)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
+	zipFilePart, err := writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
 
-		_, err = io.Copy(zipFilePart, file)
+	if fileInfo.IsDir() {
+		return nil
+	}
+
+	if fileInfo.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(fullPath)
 		if err != nil {
 			return err
 		}
+		_, err = zipFilePart.Write([]byte(filepath.ToSlash(target)))
+		return err
+	}
 
-		return nil
-	})
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(zipFilePart, NewContextReader(ctx, file))
+	return err
 }
 
 func (h LocalHandler) zipFileHeaderLocation(name string) (int64, error) {
@@ -2,6 +2,8 @@ package zipper_test
 
 import (
 	"archive/zip"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,6 +14,7 @@ import (
 
 	"code.cloudfoundry.org/gofileutils/fileutils"
 	. "github.com/ArthurHlt/zipper"
+	"github.com/ArthurHlt/zipper/dirfiles"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -102,6 +105,129 @@ var _ = Describe("LocalHandler", func() {
 
 			checkZipFile(zipFile)
 		})
+		It("streams a zip with unknown size when streaming is set", func() {
+			workingDir, err := os.Getwd()
+			Expect(err).NotTo(HaveOccurred())
+
+			dir := filepath.Join(workingDir, "fixtures/zip/")
+			src := NewSource(dir)
+			SetCtxStreaming(src, true)
+			zipFile, err := handler.Zip(src)
+			Expect(err).NotTo(HaveOccurred())
+			defer zipFile.Close()
+
+			Expect(zipFile.Size()).To(Equal(UnknownSize))
+			checkZipFile(zipFile)
+		})
+	})
+	Describe("Zip with symlinks", func() {
+		var appDir string
+		BeforeEach(func() {
+			if runtime.GOOS == "windows" {
+				Skip("This test is only for non-Windows platforms")
+			}
+			var err error
+			appDir, err = ioutil.TempDir("", "symlinks")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(appDir, "file.txt"), []byte("content"), 0644)).To(Succeed())
+			Expect(os.Symlink("file.txt", filepath.Join(appDir, "link.txt"))).To(Succeed())
+		})
+		AfterEach(func() {
+			os.RemoveAll(appDir)
+		})
+		zipFiles := func(mode SymlinkMode) *zip.Reader {
+			src := NewSource(appDir)
+			SetCtxSymlinkMode(src, mode)
+			zipFile, err := handler.Zip(src)
+			Expect(err).NotTo(HaveOccurred())
+			defer zipFile.Close()
+			b, err := ioutil.ReadAll(zipFile)
+			Expect(err).NotTo(HaveOccurred())
+			reader, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+			Expect(err).NotTo(HaveOccurred())
+			return reader
+		}
+		It("stores symlinks with their target as content when preserving them", func() {
+			reader := zipFiles(SymlinkPreserve)
+			Expect(reader.File).To(HaveLen(2))
+			name, content := readFileInZip(1, reader)
+			Expect(name).To(Equal("link.txt"))
+			Expect(content).To(Equal("file.txt"))
+			Expect(reader.File[1].Mode() & os.ModeSymlink).ToNot(BeZero())
+		})
+		It("stores content of symlink targets when following them", func() {
+			reader := zipFiles(SymlinkFollow)
+			Expect(reader.File).To(HaveLen(2))
+			name, content := readFileInZip(1, reader)
+			Expect(name).To(Equal("link.txt"))
+			Expect(content).To(Equal("content"))
+			Expect(reader.File[1].Mode().IsRegular()).To(BeTrue())
+		})
+	})
+	Describe("Zip with .gitignore", func() {
+		var appDir string
+		BeforeEach(func() {
+			var err error
+			appDir, err = ioutil.TempDir("", "gitignore")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(appDir, ".gitignore"), []byte("*.tmp\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(appDir, "file.tmp"), []byte("tmp"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(appDir, "file.txt"), []byte("content"), 0644)).To(Succeed())
+		})
+		AfterEach(func() {
+			os.RemoveAll(appDir)
+		})
+		zipFileNames := func(gitIgnore bool) []string {
+			src := NewSource(appDir)
+			SetCtxGitIgnore(src, gitIgnore)
+			zipFile, err := handler.Zip(src)
+			Expect(err).NotTo(HaveOccurred())
+			defer zipFile.Close()
+			b, err := ioutil.ReadAll(zipFile)
+			Expect(err).NotTo(HaveOccurred())
+			reader, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+			Expect(err).NotTo(HaveOccurred())
+			names := make([]string, 0)
+			for _, file := range reader.File {
+				names = append(names, file.Name)
+			}
+			return names
+		}
+		It("doesn't honour .gitignore by default", func() {
+			Expect(zipFileNames(false)).To(Equal([]string{"file.tmp", "file.txt"}))
+		})
+		It("excludes files ignored by .gitignore when asked", func() {
+			Expect(zipFileNames(true)).To(Equal([]string{"file.txt"}))
+		})
+	})
+	Describe("Zip with include and exclude patterns", func() {
+		It("creates a zip with only included and not excluded files", func() {
+			workingDir, err := os.Getwd()
+			Expect(err).NotTo(HaveOccurred())
+
+			src := NewSource(filepath.Join(workingDir, "fixtures/zip/"))
+			SetCtxIncludes(src, []string{"*.txt"})
+			SetCtxExcludes(src, []string{"subDir/otherDir/"})
+			zipFile, err := handler.Zip(src)
+			Expect(err).NotTo(HaveOccurred())
+			defer zipFile.Close()
+			b, err := ioutil.ReadAll(zipFile)
+			Expect(err).NotTo(HaveOccurred())
+			reader, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+			Expect(err).NotTo(HaveOccurred())
+
+			names := make([]string, 0)
+			for _, file := range reader.File {
+				names = append(names, file.Name)
+			}
+			Expect(names).To(Equal([]string{
+				"foo.txt",
+				"largeblankfile/",
+				"largeblankfile/file.txt",
+				"subDir/",
+				"subDir/bar.txt",
+			}))
+		})
 	})
 	Describe("Detect", func() {
 		It("should return true if path exists on system", func() {
@@ -128,6 +254,32 @@ var _ = Describe("LocalHandler", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(sha1).ShouldNot(BeEmpty())
 		})
+		Context("when using content hash", func() {
+			It("should create sha1 from content of every files without creating a zip", func() {
+				workingDir, err := os.Getwd()
+				Expect(err).NotTo(HaveOccurred())
+				dir := filepath.Join(workingDir, "fixtures/zip/")
+				handler.ContentHash = true
+
+				sha1, err := handler.Sha1(NewSource(dir))
+				Expect(err).NotTo(HaveOccurred())
+
+				contentSha1, err := dirfiles.DirFiles{}.ContentSha1(dir)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sha1).Should(Equal(contentSha1))
+			})
+			It("should stop and return context error when source context is cancelled", func() {
+				workingDir, err := os.Getwd()
+				Expect(err).NotTo(HaveOccurred())
+				dir := filepath.Join(workingDir, "fixtures/zip/")
+				handler.ContentHash = true
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				_, err = handler.Sha1(NewSource(dir).WithContext(ctx))
+				Expect(err).To(Equal(context.Canceled))
+			})
+		})
 	})
 	Describe("ZipFiles", func() {
 
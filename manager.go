@@ -1,6 +1,7 @@
 package zipper
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -10,12 +11,29 @@ import (
 var fManager *Manager = mustNewManager(
 	NewGitHandler(),
 	&HttpHandler{},
+	&S3Handler{},
 	&LocalHandler{},
 )
 
 type Manager struct {
-	handlers   map[string]Handler
-	httpClient *http.Client
+	handlers      map[string]Handler
+	signatures    map[string]SignatureStrategy
+	signature     string
+	formats       map[string]OutputFormat
+	format        string
+	converters    []FormatConverter
+	decompressors []Decompressor
+	httpClient    *http.Client
+	streaming     bool
+	symlinks      SymlinkMode
+	reproducible  bool
+	gitIgnore     bool
+	archiveIgnore bool
+	gitCacheDir   string
+	s3Credentials *S3Credentials
+	credentials   CredentialProvider
+	plainHttp     bool
+	gitSsh        *GitSshOptions
 }
 
 func mustNewManager(handlers ...Handler) *Manager {
@@ -29,12 +47,34 @@ func mustNewManager(handlers ...Handler) *Manager {
 // Create new manager with given zip handlers
 func NewManager(handlers ...Handler) (*Manager, error) {
 	m := &Manager{
-		handlers: make(map[string]Handler),
+		handlers:      make(map[string]Handler),
+		signatures:    make(map[string]SignatureStrategy),
+		formats:       make(map[string]OutputFormat),
+		converters:    DefaultFormatConverters(),
+		decompressors: DefaultDecompressors(),
 		httpClient: &http.Client{
 			Timeout: 0,
 		},
 	}
-	err := m.AddHandlers(handlers...)
+	err := m.AddSignatureStrategies(
+		FastSignature{},
+		FullSignature{},
+		MetadataSignature{},
+		ContentSignature{},
+	)
+	if err != nil {
+		return m, err
+	}
+	err = m.AddOutputFormats(
+		ZipFormat{},
+		TarFormat{},
+		TarGzFormat{},
+		TarZstFormat{},
+	)
+	if err != nil {
+		return m, err
+	}
+	err = m.AddHandlers(handlers...)
 	return m, err
 }
 
@@ -51,6 +91,140 @@ func SetHttpClient(httpClient *http.Client) {
 	fManager.SetHttpClient(httpClient)
 }
 
+// Set s3 credentials for zip handlers which need it
+// When not set, s3 credentials are retrieved from environment variables
+func (m *Manager) SetS3Credentials(creds *S3Credentials) {
+	m.s3Credentials = creds
+}
+
+// For default manager
+//
+// Set s3 credentials for zip handlers which need it
+// When not set, s3 credentials are retrieved from environment variables
+func SetS3Credentials(creds *S3Credentials) {
+	fManager.SetS3Credentials(creds)
+}
+
+// Set to true to create sessions which stream zip while it's read instead of buffering it in a temp file
+// Zip size will be unknown in this case (ZipReadCloser.Size() will return UnknownSize)
+func (m *Manager) SetStreaming(streaming bool) {
+	m.streaming = streaming
+}
+
+// For default manager
+//
+// Set to true to create sessions which stream zip while it's read instead of buffering it in a temp file
+// Zip size will be unknown in this case (ZipReadCloser.Size() will return UnknownSize)
+func SetStreaming(streaming bool) {
+	fManager.SetStreaming(streaming)
+}
+
+// Set how symlinks found in directories and tarballs are handled in created sessions
+func (m *Manager) SetSymlinkMode(mode SymlinkMode) {
+	m.symlinks = mode
+}
+
+// For default manager
+//
+// Set how symlinks found in directories and tarballs are handled in created sessions
+func SetSymlinkMode(mode SymlinkMode) {
+	fManager.SetSymlinkMode(mode)
+}
+
+// Set to true to create sessions which create byte-for-byte reproducible zip (see NewReproducibleZip)
+func (m *Manager) SetReproducible(reproducible bool) {
+	m.reproducible = reproducible
+}
+
+// For default manager
+//
+// Set to true to create sessions which create byte-for-byte reproducible zip (see NewReproducibleZip)
+func SetReproducible(reproducible bool) {
+	fManager.SetReproducible(reproducible)
+}
+
+// Set to true to create sessions which honour .gitignore files and .git/info/exclude
+// in addition to .cfignore, .cloudignore and .zipignore files
+func (m *Manager) SetGitIgnore(gitIgnore bool) {
+	m.gitIgnore = gitIgnore
+}
+
+// For default manager
+//
+// Set to true to create sessions which honour .gitignore files and .git/info/exclude
+// in addition to .cfignore, .cloudignore and .zipignore files
+func SetGitIgnore(gitIgnore bool) {
+	fManager.SetGitIgnore(gitIgnore)
+}
+
+// Set directory where created sessions keep git repositories as bare mirrors between calls, only new objects are then fetched
+// Cache is disabled when dir is empty
+func (m *Manager) SetGitCacheDir(dir string) {
+	m.gitCacheDir = dir
+}
+
+// For default manager
+//
+// Set directory where created sessions keep git repositories as bare mirrors between calls, only new objects are then fetched
+// Cache is disabled when dir is empty
+func SetGitCacheDir(dir string) {
+	fManager.SetGitCacheDir(dir)
+}
+
+// Set a provider of credentials for git and http urls without credentials inside them,
+// it's used before default providers (environment, netrc file and git credential helpers)
+func (m *Manager) SetCredentialProvider(provider CredentialProvider) {
+	m.credentials = provider
+}
+
+// For default manager
+//
+// Set a provider of credentials for git and http urls without credentials inside them,
+// it's used before default providers (environment, netrc file and git credential helpers)
+func SetCredentialProvider(provider CredentialProvider) {
+	fManager.SetCredentialProvider(provider)
+}
+
+// Set to true to create sessions which send credentials from default providers (environment, netrc file
+// and git credential helpers) to plain http urls, they are only sent to https urls otherwise
+func (m *Manager) SetPlainHttpCredentials(allow bool) {
+	m.plainHttp = allow
+}
+
+// For default manager
+//
+// Set to true to create sessions which send credentials from default providers (environment, netrc file
+// and git credential helpers) to plain http urls, they are only sent to https urls otherwise
+func SetPlainHttpCredentials(allow bool) {
+	fManager.SetPlainHttpCredentials(allow)
+}
+
+// Set options for git repositories reached over ssh in created sessions: known hosts files, host key fingerprints,
+// insecure mode and private key in PEM format
+func (m *Manager) SetGitSshOptions(options *GitSshOptions) {
+	m.gitSsh = options
+}
+
+// For default manager
+//
+// Set options for git repositories reached over ssh in created sessions: known hosts files, host key fingerprints,
+// insecure mode and private key in PEM format
+func SetGitSshOptions(options *GitSshOptions) {
+	fManager.SetGitSshOptions(options)
+}
+
+// Set to true to create sessions which honour ignore files (.zipignore, ...) found inside an archive source (zip, tar, ...)
+func (m *Manager) SetArchiveIgnore(archiveIgnore bool) {
+	m.archiveIgnore = archiveIgnore
+}
+
+// For default manager
+//
+// Set to true to create sessions which honour ignore files (.zipignore, ...) found inside an archive source (zip, tar, ...)
+func SetArchiveIgnore(archiveIgnore bool) {
+	fManager.SetArchiveIgnore(archiveIgnore)
+}
+
 // For default manager
 //
 // Create a session for a given path with given handler type.
@@ -72,7 +246,73 @@ func (m *Manager) CreateSession(path string, handlerNames ...string) (*Session,
 	}
 	src := NewSource(path)
 	SetCtxHttpClient(src, m.httpClient)
-	return NewSession(src, h), nil
+	SetCtxStreaming(src, m.streaming)
+	SetCtxFormatConverters(src, m.converters)
+	SetCtxDecompressors(src, m.decompressors)
+	SetCtxSymlinkMode(src, m.symlinks)
+	SetCtxReproducible(src, m.reproducible)
+	SetCtxGitIgnore(src, m.gitIgnore)
+	SetCtxArchiveIgnore(src, m.archiveIgnore)
+	SetCtxPlainHttpCredentials(src, m.plainHttp)
+	if m.gitCacheDir != "" {
+		SetCtxGitCacheDir(src, m.gitCacheDir)
+	}
+	if m.s3Credentials != nil {
+		SetCtxS3Credentials(src, m.s3Credentials)
+	}
+	if m.credentials != nil {
+		SetCtxCredentialProvider(src, m.credentials)
+	}
+	if m.gitSsh != nil {
+		SetCtxGitSshOptions(src, m.gitSsh)
+	}
+	session := NewSession(src, h)
+	if m.signature != "" {
+		err = session.SetSignatureStrategy(m.signatures[m.signature])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if m.format != "" {
+		session.SetOutputFormat(m.formats[m.format])
+	}
+	return session, nil
+}
+
+// For default manager
+//
+// Create zip file for a given path with given handler type, creation is aborted when context is cancelled.
+// Omitting handler type will use auto detection
+func ZipContext(ctx context.Context, path string, handlerNames ...string) (ZipReadCloser, error) {
+	return fManager.ZipContext(ctx, path, handlerNames...)
+}
+
+// Create zip file for a given path with given handler type, creation is aborted when context is cancelled.
+// Omitting handler type will use auto detection
+func (m *Manager) ZipContext(ctx context.Context, path string, handlerNames ...string) (ZipReadCloser, error) {
+	s, err := m.CreateSession(path, handlerNames...)
+	if err != nil {
+		return nil, err
+	}
+	return s.ZipContext(ctx)
+}
+
+// For default manager
+//
+// Retrieve signature for a given path with given handler type, retrieving is aborted when context is cancelled.
+// Omitting handler type will use auto detection
+func Sha1Context(ctx context.Context, path string, handlerNames ...string) (string, error) {
+	return fManager.Sha1Context(ctx, path, handlerNames...)
+}
+
+// Retrieve signature for a given path with given handler type, retrieving is aborted when context is cancelled.
+// Omitting handler type will use auto detection
+func (m *Manager) Sha1Context(ctx context.Context, path string, handlerNames ...string) (string, error) {
+	s, err := m.CreateSession(path, handlerNames...)
+	if err != nil {
+		return "", err
+	}
+	return s.Sha1Context(ctx)
 }
 
 // For default manager
@@ -135,3 +375,167 @@ func (m *Manager) FindHandler(path string, handlerName string) (Handler, error)
 	}
 	return nil, fmt.Errorf("Handler for path '%s' cannot be found.", src.Path)
 }
+
+// For default manager
+//
+// Add new signature strategies to manager
+func AddSignatureStrategies(strategies ...SignatureStrategy) error {
+	return fManager.AddSignatureStrategies(strategies...)
+}
+
+// Add new signature strategies to manager
+func (m *Manager) AddSignatureStrategies(strategies ...SignatureStrategy) error {
+	for _, strategy := range strategies {
+		name := strings.ToLower(strategy.Name())
+		if _, ok := m.signatures[name]; ok {
+			return fmt.Errorf("Signature strategy %s already exists", name)
+		}
+		m.signatures[name] = strategy
+	}
+	return nil
+}
+
+// For default manager
+//
+// Find signature strategy by its name
+func FindSignatureStrategy(name string) (SignatureStrategy, error) {
+	return fManager.FindSignatureStrategy(name)
+}
+
+// Find signature strategy by its name
+func (m *Manager) FindSignatureStrategy(name string) (SignatureStrategy, error) {
+	if strategy, ok := m.signatures[strings.ToLower(name)]; ok {
+		return strategy, nil
+	}
+	return nil, fmt.Errorf("Signature strategy %s cannot be found.", name)
+}
+
+// For default manager
+//
+// Set signature strategy, by its name, to use in created sessions
+// Empty name means handler default signature (fast signature strategy)
+func SetSignatureStrategy(name string) error {
+	return fManager.SetSignatureStrategy(name)
+}
+
+// Set signature strategy, by its name, to use in created sessions
+// Empty name means handler default signature (fast signature strategy)
+func (m *Manager) SetSignatureStrategy(name string) error {
+	name = strings.ToLower(name)
+	if name != "" {
+		if _, err := m.FindSignatureStrategy(name); err != nil {
+			return err
+		}
+	}
+	m.signature = name
+	return nil
+}
+
+// For default manager
+//
+// Add new format converters to manager
+// They are tried in given order before already added format converters
+func AddFormatConverters(converters ...FormatConverter) {
+	fManager.AddFormatConverters(converters...)
+}
+
+// Add new format converters to manager
+// They are tried in given order before already added format converters
+func (m *Manager) AddFormatConverters(converters ...FormatConverter) {
+	m.converters = append(append([]FormatConverter{}, converters...), m.converters...)
+}
+
+// For default manager
+//
+// Replace every format converters of manager, built-in ones included (see DefaultFormatConverters)
+// e.g.: leaving out ZipConverter makes zip content be handled as any other file
+func SetFormatConverters(converters ...FormatConverter) {
+	fManager.SetFormatConverters(converters...)
+}
+
+// Replace every format converters of manager, built-in ones included (see DefaultFormatConverters)
+// e.g.: leaving out ZipConverter makes zip content be handled as any other file
+func (m *Manager) SetFormatConverters(converters ...FormatConverter) {
+	m.converters = append([]FormatConverter{}, converters...)
+}
+
+// For default manager
+//
+// Add new decompressors to manager
+// They are tried in given order before already added decompressors
+func AddDecompressors(decompressors ...Decompressor) {
+	fManager.AddDecompressors(decompressors...)
+}
+
+// Add new decompressors to manager
+// They are tried in given order before already added decompressors
+func (m *Manager) AddDecompressors(decompressors ...Decompressor) {
+	m.decompressors = append(append([]Decompressor{}, decompressors...), m.decompressors...)
+}
+
+// For default manager
+//
+// Replace every decompressors of manager, built-in ones included (see DefaultDecompressors)
+func SetDecompressors(decompressors ...Decompressor) {
+	fManager.SetDecompressors(decompressors...)
+}
+
+// Replace every decompressors of manager, built-in ones included (see DefaultDecompressors)
+func (m *Manager) SetDecompressors(decompressors ...Decompressor) {
+	m.decompressors = append([]Decompressor{}, decompressors...)
+}
+
+// For default manager
+//
+// Add new output formats to manager
+func AddOutputFormats(formats ...OutputFormat) error {
+	return fManager.AddOutputFormats(formats...)
+}
+
+// Add new output formats to manager
+func (m *Manager) AddOutputFormats(formats ...OutputFormat) error {
+	for _, format := range formats {
+		name := strings.ToLower(format.Name())
+		if _, ok := m.formats[name]; ok {
+			return fmt.Errorf("Output format %s already exists", name)
+		}
+		m.formats[name] = format
+	}
+	return nil
+}
+
+// For default manager
+//
+// Find output format by its name
+func FindOutputFormat(name string) (OutputFormat, error) {
+	return fManager.FindOutputFormat(name)
+}
+
+// Find output format by its name
+func (m *Manager) FindOutputFormat(name string) (OutputFormat, error) {
+	if format, ok := m.formats[strings.ToLower(name)]; ok {
+		return format, nil
+	}
+	return nil, fmt.Errorf("Output format %s cannot be found.", name)
+}
+
+// For default manager
+//
+// Set output format, by its name, to use in created sessions
+// Empty name means zip format
+func SetOutputFormat(name string) error {
+	return fManager.SetOutputFormat(name)
+}
+
+// Set output format, by its name, to use in created sessions
+// Empty name means zip format
+func (m *Manager) SetOutputFormat(name string) error {
+	name = strings.ToLower(name)
+	if name != "" {
+		if _, err := m.FindOutputFormat(name); err != nil {
+			return err
+		}
+	}
+	m.format = name
+	return nil
+}
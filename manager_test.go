@@ -107,5 +107,87 @@ var _ = Describe("Manager", func() {
 				Expect(err).To(HaveOccurred())
 			})
 		})
+		Context("when a signature strategy is set", func() {
+			It("should give session with this signature strategy", func() {
+				err := manager.SetSignatureStrategy("full")
+				Expect(err).ToNot(HaveOccurred())
+
+				s, err := manager.CreateSession("fake1")
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(s.SignatureStrategy().Name()).Should(Equal(SignatureFull))
+			})
+			It("should return error when handler doesn't support this signature strategy", func() {
+				err := manager.SetSignatureStrategy("metadata")
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = manager.CreateSession("fake1")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+		Context("when an output format is set", func() {
+			It("should give session with this output format", func() {
+				err := manager.SetOutputFormat("tar.gz")
+				Expect(err).ToNot(HaveOccurred())
+
+				s, err := manager.CreateSession("fake1")
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(s.OutputFormat().Name()).Should(Equal(FormatTarGz))
+			})
+		})
+	})
+	Describe("AddFormatConverters", func() {
+		It("should give format converters to sessions before default ones", func() {
+			manager.AddFormatConverters(&fakeConverter{})
+			s, err := manager.CreateSession("fake1")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(CtxFormatConverters(s.Source())).To(Equal(append(
+				[]FormatConverter{&fakeConverter{}},
+				DefaultFormatConverters()...,
+			)))
+		})
+	})
+	Describe("SetFormatConverters", func() {
+		It("should replace every format converters given to sessions", func() {
+			manager.SetFormatConverters(&fakeConverter{}, TarConverter{})
+			s, err := manager.CreateSession("fake1")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(CtxFormatConverters(s.Source())).To(Equal([]FormatConverter{&fakeConverter{}, TarConverter{}}))
+		})
+	})
+	Describe("AddDecompressors", func() {
+		It("should give decompressors to sessions before default ones", func() {
+			manager.AddDecompressors(reverseDecompressor{})
+			s, err := manager.CreateSession("fake1")
+			Expect(err).ToNot(HaveOccurred())
+
+			decompressors := CtxDecompressors(s.Source())
+			Expect(decompressors).To(HaveLen(len(DefaultDecompressors()) + 1))
+			Expect(decompressors[0]).To(Equal(reverseDecompressor{}))
+		})
+	})
+	Describe("SetDecompressors", func() {
+		It("should replace every decompressors given to sessions", func() {
+			manager.SetDecompressors(reverseDecompressor{})
+			s, err := manager.CreateSession("fake1")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(CtxDecompressors(s.Source())).To(Equal([]Decompressor{reverseDecompressor{}}))
+		})
+	})
+	Describe("SetSignatureStrategy", func() {
+		It("should return error when signature strategy doesn't exists", func() {
+			err := manager.SetSignatureStrategy("notexists")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Describe("SetOutputFormat", func() {
+		It("should return error when output format doesn't exists", func() {
+			err := manager.SetOutputFormat("notexists")
+			Expect(err).To(HaveOccurred())
+		})
 	})
 })
@@ -0,0 +1,377 @@
+package zipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// Zip archive, format produced by handlers
+	FormatZip = "zip"
+	// Tar archive
+	FormatTar = "tar"
+	// Tar archive compressed with gzip
+	FormatTarGz = "tar.gz"
+	// Tar archive compressed with zstd
+	FormatTarZst = "tar.zst"
+)
+
+// Format of archive created by a session
+// Archive is created from entries of the zip given by handler,
+// entries order, modes and modification times are the same in every formats
+type OutputFormat interface {
+	// Write every entries from zip reader to w in this format
+	FromZip(r *zip.Reader, w io.Writer) error
+	// Extension of a file in this format (e.g.: .tar.gz)
+	Ext() string
+	Name() string
+}
+
+// Writer of archive entries given as zip file headers, *zip.Writer is the one of zip format
+type EntryWriter interface {
+	// Add an entry, its content must be entirely written to the returned writer before adding next entry
+	CreateHeader(fh *zip.FileHeader) (io.Writer, error)
+	// Finish writing archive, underlying writer is not closed
+	Close() error
+}
+
+// Output format which can be written entry by entry
+// Handlers write archive straight in this format while walking their files instead of creating a zip first (see CtxOutputFormat)
+type EntryOutputFormat interface {
+	OutputFormat
+	NewEntryWriter(w io.Writer) (EntryWriter, error)
+}
+
+// Archive in zip format, zip given by handler is used as it is
+type ZipFormat struct {
+}
+
+func (f ZipFormat) FromZip(r *zip.Reader, w io.Writer) error {
+	zipWriter := zip.NewWriter(w)
+	defer zipWriter.Close()
+	for _, file := range r.File {
+		fw, err := zipWriter.CreateRaw(&file.FileHeader)
+		if err != nil {
+			return err
+		}
+		rawReader, err := file.OpenRaw()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, rawReader)
+		if err != nil {
+			return err
+		}
+	}
+	return zipWriter.Close()
+}
+
+func (f ZipFormat) NewEntryWriter(w io.Writer) (EntryWriter, error) {
+	return zip.NewWriter(w), nil
+}
+
+func (f ZipFormat) Ext() string {
+	return ".zip"
+}
+
+func (f ZipFormat) Name() string {
+	return FormatZip
+}
+
+// Archive in tar format
+type TarFormat struct {
+}
+
+func (f TarFormat) FromZip(r *zip.Reader, w io.Writer) error {
+	return writeEntriesFromZip(r, f, w)
+}
+
+func (f TarFormat) NewEntryWriter(w io.Writer) (EntryWriter, error) {
+	return newTarEntryWriter(w, nil), nil
+}
+
+func (f TarFormat) Ext() string {
+	return ".tar"
+}
+
+func (f TarFormat) Name() string {
+	return FormatTar
+}
+
+// Archive in tar format compressed with gzip
+type TarGzFormat struct {
+}
+
+func (f TarGzFormat) FromZip(r *zip.Reader, w io.Writer) error {
+	return writeEntriesFromZip(r, f, w)
+}
+
+func (f TarGzFormat) NewEntryWriter(w io.Writer) (EntryWriter, error) {
+	gzWriter := gzip.NewWriter(w)
+	return newTarEntryWriter(gzWriter, gzWriter), nil
+}
+
+func (f TarGzFormat) Ext() string {
+	return ".tar.gz"
+}
+
+func (f TarGzFormat) Name() string {
+	return FormatTarGz
+}
+
+// Archive in tar format compressed with zstd
+type TarZstFormat struct {
+}
+
+func (f TarZstFormat) FromZip(r *zip.Reader, w io.Writer) error {
+	return writeEntriesFromZip(r, f, w)
+}
+
+func (f TarZstFormat) NewEntryWriter(w io.Writer) (EntryWriter, error) {
+	zstWriter, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return newTarEntryWriter(zstWriter, zstWriter), nil
+}
+
+func (f TarZstFormat) Ext() string {
+	return ".tar.zst"
+}
+
+func (f TarZstFormat) Name() string {
+	return FormatTarZst
+}
+
+// write every entries from zip with an entry writer of format
+func writeEntriesFromZip(r *zip.Reader, format EntryOutputFormat, w io.Writer) error {
+	entryWriter, err := format.NewEntryWriter(w)
+	if err != nil {
+		return err
+	}
+	defer entryWriter.Close()
+	for _, file := range r.File {
+		header := file.FileHeader
+		fw, err := entryWriter.CreateHeader(&header)
+		if err != nil {
+			return err
+		}
+		if file.Mode().IsDir() {
+			continue
+		}
+		fr, err := file.Open()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, fr)
+		fr.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return entryWriter.Close()
+}
+
+// size of entry content kept in memory before being moved in a temp file
+const spoolMemSize = 1 << 20
+
+// Write entries in a tar, symlinks are written as tar symlinks with content as target
+// Content of a file which size is not set in its header is buffered until next entry to know its size
+type tarEntryWriter struct {
+	tarWriter *tar.Writer
+	// compression writer closed after tar, nil when tar is not compressed
+	compressor io.WriteCloser
+	// entry waiting for its content to be entirely written
+	pending *tar.Header
+	spool   *entrySpool
+	closed  bool
+}
+
+func newTarEntryWriter(w io.Writer, compressor io.WriteCloser) *tarEntryWriter {
+	return &tarEntryWriter{
+		tarWriter:  tar.NewWriter(w),
+		compressor: compressor,
+	}
+}
+
+func (t *tarEntryWriter) CreateHeader(fh *zip.FileHeader) (io.Writer, error) {
+	err := t.flush()
+	if err != nil {
+		return nil, err
+	}
+	mode := fh.Mode()
+	modTime := fh.Modified
+	if modTime.IsZero() {
+		modTime = fh.ModTime()
+	}
+	header := &tar.Header{
+		Name: fh.Name,
+		Mode: int64(mode.Perm()),
+		// zip keeps modification time to the second
+		ModTime: modTime.Truncate(time.Second),
+	}
+	switch {
+	case mode.IsDir():
+		header.Typeflag = tar.TypeDir
+		return ioutil.Discard, t.tarWriter.WriteHeader(header)
+	case mode&os.ModeSymlink != 0:
+		header.Typeflag = tar.TypeSymlink
+	case fh.UncompressedSize64 > 0:
+		header.Typeflag = tar.TypeReg
+		header.Size = int64(fh.UncompressedSize64)
+		return t.tarWriter, t.tarWriter.WriteHeader(header)
+	default:
+		header.Typeflag = tar.TypeReg
+	}
+	t.pending = header
+	t.spool = &entrySpool{}
+	return t.spool, nil
+}
+
+// write pending entry now that its content is known
+func (t *tarEntryWriter) flush() error {
+	if t.pending == nil {
+		return nil
+	}
+	header, spool := t.pending, t.spool
+	t.pending, t.spool = nil, nil
+	defer spool.Close()
+	r, err := spool.reader()
+	if err != nil {
+		return err
+	}
+	if header.Typeflag == tar.TypeSymlink {
+		target, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		header.Linkname = string(target)
+		return t.tarWriter.WriteHeader(header)
+	}
+	header.Size = spool.size
+	err = t.tarWriter.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(t.tarWriter, r)
+	return err
+}
+
+func (t *tarEntryWriter) Close() error {
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	err := t.flush()
+	if err != nil {
+		return err
+	}
+	err = t.tarWriter.Close()
+	if err != nil || t.compressor == nil {
+		return err
+	}
+	return t.compressor.Close()
+}
+
+// content of an entry kept in memory, or in a temp file when it's bigger than spoolMemSize
+type entrySpool struct {
+	buf  bytes.Buffer
+	file *os.File
+	size int64
+}
+
+func (s *entrySpool) Write(p []byte) (int, error) {
+	if s.file == nil && s.buf.Len()+len(p) > spoolMemSize {
+		file, err := ioutil.TempFile("", "entry-zipper")
+		if err != nil {
+			return 0, err
+		}
+		s.file = file
+		_, err = s.buf.WriteTo(file)
+		if err != nil {
+			return 0, err
+		}
+	}
+	var n int
+	var err error
+	if s.file != nil {
+		n, err = s.file.Write(p)
+	} else {
+		n, err = s.buf.Write(p)
+	}
+	s.size += int64(n)
+	return n, err
+}
+
+func (s *entrySpool) reader() (io.Reader, error) {
+	if s.file == nil {
+		return &s.buf, nil
+	}
+	_, err := s.file.Seek(0, io.SeekStart)
+	return s.file, err
+}
+
+func (s *entrySpool) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
+
+// Create an archive in given format from a zip, zip is closed when archive is closed
+// Archive given by handler is used as it is when handler has already written it in this format (see CtxOutputFormat),
+// otherwise zip is buffered in a temp file when it's not already in a file because zip entries must be read from the end of the zip
+func NewArchive(src *Source, zipFile ZipReadCloser, format OutputFormat) (ZipReadCloser, error) {
+	if format.Name() == FormatZip || archiveFormat(zipFile) == format.Name() {
+		return zipFile, nil
+	}
+	file, size, clean, err := zipInFile(zipFile)
+	if err != nil {
+		return nil, err
+	}
+	zipReader, err := zip.NewReader(file, size)
+	if err != nil {
+		clean()
+		return nil, err
+	}
+	return makeZipFile(src, "archive-zipper", func(w io.Writer) error {
+		return format.FromZip(zipReader, w)
+	}, clean)
+}
+
+// retrieve zip as a file, clean function closes zip and removes temp file if one has been created
+func zipInFile(zipFile ZipReadCloser) (*os.File, int64, func() error, error) {
+	if f, ok := zipFile.(*ZipFile); ok {
+		if file, ok := f.file.(*os.File); ok && f.size != UnknownSize {
+			return file, f.size, zipFile.Close, nil
+		}
+	}
+	tmpFile, err := ioutil.TempFile("", "zip-zipper")
+	if err != nil {
+		zipFile.Close()
+		return nil, 0, nil, err
+	}
+	clean := func() error {
+		tmpFile.Close()
+		err := os.Remove(tmpFile.Name())
+		if err != nil {
+			return err
+		}
+		return zipFile.Close()
+	}
+	size, err := io.Copy(tmpFile, zipFile)
+	if err != nil {
+		clean()
+		return nil, 0, nil, err
+	}
+	return tmpFile, size, clean, nil
+}
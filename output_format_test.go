@@ -0,0 +1,144 @@
+package zipper_test
+
+import (
+	. "github.com/ArthurHlt/zipper"
+
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OutputFormat", func() {
+	type entry struct {
+		name    string
+		mode    os.FileMode
+		modTime int64
+		content string
+	}
+	var appPath string
+	var zipEntries []entry
+
+	BeforeEach(func() {
+		workingDir, err := os.Getwd()
+		Expect(err).NotTo(HaveOccurred())
+		appPath = filepath.Join(workingDir, "fixtures", "applications", "app-copy-test")
+
+		zipEntries = make([]entry, 0)
+		zipFile, err := NewSession(NewSource(appPath), &LocalHandler{}).Archive()
+		Expect(err).NotTo(HaveOccurred())
+		defer zipFile.Close()
+		b, err := ioutil.ReadAll(zipFile)
+		Expect(err).NotTo(HaveOccurred())
+		reader, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		Expect(err).NotTo(HaveOccurred())
+		for i, file := range reader.File {
+			_, content := readFileInZip(i, reader)
+			zipEntries = append(zipEntries, entry{file.Name, file.Mode().Perm(), file.Modified.Unix(), content})
+		}
+		Expect(zipEntries).ToNot(BeEmpty())
+	})
+	tarEntries := func(r io.Reader) []entry {
+		entries := make([]entry, 0)
+		tarReader := tar.NewReader(r)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+			content, err := ioutil.ReadAll(tarReader)
+			Expect(err).NotTo(HaveOccurred())
+			entries = append(entries, entry{header.Name, header.FileInfo().Mode().Perm(), header.ModTime.Unix(), string(content)})
+		}
+		return entries
+	}
+
+	for _, streaming := range []bool{false, true} {
+		streaming := streaming
+		for _, format := range []OutputFormat{TarFormat{}, TarGzFormat{}, TarZstFormat{}} {
+			format := format
+			It("should create a "+format.Name()+" archive with same entries as zip", func() {
+				session := NewSession(NewSource(appPath), &LocalHandler{})
+				session.SetStreaming(streaming)
+				session.SetOutputFormat(format)
+				archive, err := session.Archive()
+				Expect(err).NotTo(HaveOccurred())
+				defer archive.Close()
+
+				var r io.Reader = archive
+				switch format.Name() {
+				case FormatTarGz:
+					r, err = gzip.NewReader(archive)
+					Expect(err).NotTo(HaveOccurred())
+				case FormatTarZst:
+					zr, err := zstd.NewReader(archive)
+					Expect(err).NotTo(HaveOccurred())
+					defer zr.Close()
+					r = zr
+				}
+				Expect(tarEntries(r)).To(Equal(zipEntries))
+			})
+		}
+	}
+	It("should write tar straight from handler without buffering a zip when streaming", func() {
+		tmpDir, err := ioutil.TempDir("", "archive-test")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(tmpDir)
+		oldTmpDir := os.Getenv("TMPDIR")
+		os.Setenv("TMPDIR", tmpDir)
+		defer os.Setenv("TMPDIR", oldTmpDir)
+
+		session := NewSession(NewSource(appPath), &LocalHandler{})
+		session.SetStreaming(true)
+		session.SetOutputFormat(TarFormat{})
+		archive, err := session.Archive()
+		Expect(err).NotTo(HaveOccurred())
+		defer archive.Close()
+
+		entries := tarEntries(archive)
+		Expect(entries).To(Equal(zipEntries))
+		tmpFiles, err := ioutil.ReadDir(tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tmpFiles).To(BeEmpty())
+	})
+	It("should convert zip from handler which can't write entries in output format", func() {
+		zipPath := filepath.Join(filepath.Dir(appPath), "final.zip")
+		zipFile, err := NewSession(NewSource(zipPath), &LocalHandler{}).Zip()
+		Expect(err).NotTo(HaveOccurred())
+		defer zipFile.Close()
+		b, err := ioutil.ReadAll(zipFile)
+		Expect(err).NotTo(HaveOccurred())
+		reader, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		Expect(err).NotTo(HaveOccurred())
+		expected := make([]entry, 0)
+		for i, file := range reader.File {
+			_, content := readFileInZip(i, reader)
+			expected = append(expected, entry{file.Name, file.Mode().Perm(), file.Modified.Unix(), content})
+		}
+
+		session := NewSession(NewSource(zipPath), &LocalHandler{})
+		session.SetStreaming(true)
+		session.SetOutputFormat(TarFormat{})
+		archive, err := session.Archive()
+		Expect(err).NotTo(HaveOccurred())
+		defer archive.Close()
+		Expect(tarEntries(archive)).To(Equal(expected))
+	})
+	It("should give zip from handler when format is zip", func() {
+		zipFile := NewZipFile(ioutil.NopCloser(bytes.NewReader([]byte("zip content"))), 11, func() error {
+			return nil
+		})
+		archive, err := NewArchive(NewSource(appPath), zipFile, ZipFormat{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(archive).To(BeIdenticalTo(zipFile))
+	})
+})
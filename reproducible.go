@@ -0,0 +1,122 @@
+package zipper
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Compression level used for zip entries in reproducible mode
+const ReproducibleCompressionLevel = flate.BestCompression
+
+// Modification time given to every entries in reproducible mode when no source date has been found
+var ReproducibleEpoch = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Retrieve time set in SOURCE_DATE_EPOCH environment variable (see https://reproducible-builds.org/specs/source-date-epoch/)
+// ok is false when variable is not set or invalid
+func SourceDateEpoch() (date time.Time, ok bool) {
+	epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(epoch, 0).UTC(), true
+}
+
+// create zip with handler, zip is rewritten to be reproducible when asked in source context
+func handlerZip(handler Handler, src *Source) (ZipReadCloser, error) {
+	if !CtxReproducible(src) {
+		return handler.Zip(src)
+	}
+	// handler can set source date (e.g.: commit time) on its own copy of the source
+	src = src.WithContext(src.Context())
+	// entries are sorted from the zip given by handler, only the rewritten archive is in output format
+	format := CtxOutputFormat(src)
+	SetCtxOutputFormat(src, ZipFormat{})
+	zipFile, err := handler.Zip(src)
+	if err != nil {
+		return nil, err
+	}
+	SetCtxOutputFormat(src, format)
+	return NewReproducibleZip(src, zipFile)
+}
+
+// Rewrite a zip to make it byte-for-byte reproducible, in output format set in source context (see CtxOutputFormat),
+// zip is closed when reproducible zip is closed:
+//   - entries are sorted by name
+//   - modification time of every entries is source date (see CtxSourceDate)
+//   - directories have mode 0755, executable files 0755, other files 0644 and symlinks 0777
+//   - extra fields (uid/gid, ...) and comments are removed
+//   - files are compressed with ReproducibleCompressionLevel
+func NewReproducibleZip(src *Source, zipFile ZipReadCloser) (ZipReadCloser, error) {
+	file, size, clean, err := zipInFile(zipFile)
+	if err != nil {
+		return nil, err
+	}
+	zipReader, err := zip.NewReader(file, size)
+	if err != nil {
+		clean()
+		return nil, err
+	}
+	date := CtxSourceDate(src)
+	return makeArchiveFile(src, "reproducible-zipper", func(w EntryWriter) error {
+		return writeReproducibleEntries(zipReader, w, date)
+	}, clean)
+}
+
+func writeReproducibleEntries(r *zip.Reader, w EntryWriter, date time.Time) error {
+	files := append([]*zip.File{}, r.File...)
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	if zipWriter, ok := w.(*zip.Writer); ok {
+		zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, ReproducibleCompressionLevel)
+		})
+	}
+	for _, file := range files {
+		mode := reproducibleMode(file.Mode())
+		header := &zip.FileHeader{
+			Name:               file.Name,
+			Method:             zip.Deflate,
+			Modified:           date,
+			UncompressedSize64: file.UncompressedSize64,
+		}
+		if mode.IsDir() || mode&os.ModeSymlink != 0 {
+			header.Method = zip.Store
+		}
+		header.SetMode(mode)
+		fw, err := w.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if mode.IsDir() {
+			continue
+		}
+		fr, err := file.Open()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, fr)
+		fr.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func reproducibleMode(mode os.FileMode) os.FileMode {
+	switch {
+	case mode.IsDir():
+		return os.ModeDir | 0755
+	case mode&os.ModeSymlink != 0:
+		return os.ModeSymlink | 0777
+	case mode.Perm()&0111 != 0:
+		return 0755
+	}
+	return 0644
+}
@@ -0,0 +1,95 @@
+package zipper_test
+
+import (
+	. "github.com/ArthurHlt/zipper"
+
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reproducible", func() {
+	var appDir string
+	BeforeEach(func() {
+		var err error
+		appDir, err = ioutil.TempDir("", "reproducible-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.MkdirAll(filepath.Join(appDir, "sub"), 0700)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(appDir, "sub", "c.txt"), []byte("c"), 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(appDir, "b.txt"), []byte("b"), 0640)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(appDir, "a.sh"), []byte("#!/bin/sh"), 0700)).To(Succeed())
+	})
+	AfterEach(func() {
+		os.RemoveAll(appDir)
+		os.Unsetenv("SOURCE_DATE_EPOCH")
+	})
+	archive := func(format OutputFormat) []byte {
+		session := NewSession(NewSource(appDir), &LocalHandler{})
+		session.SetReproducible(true)
+		session.SetOutputFormat(format)
+		archive, err := session.Archive()
+		Expect(err).NotTo(HaveOccurred())
+		defer archive.Close()
+		b, err := ioutil.ReadAll(archive)
+		Expect(err).NotTo(HaveOccurred())
+		return b
+	}
+	touch := func() {
+		later := time.Now().Add(time.Hour)
+		for _, name := range []string{"a.sh", "b.txt", "sub", "sub/c.txt"} {
+			Expect(os.Chtimes(filepath.Join(appDir, name), later, later)).To(Succeed())
+		}
+		Expect(os.Chmod(filepath.Join(appDir, "b.txt"), 0600)).To(Succeed())
+	}
+
+	It("should create zip with sorted entries and normalized modes and modification times", func() {
+		b := archive(ZipFormat{})
+		reader, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		Expect(err).NotTo(HaveOccurred())
+
+		names := make([]string, 0)
+		for _, file := range reader.File {
+			names = append(names, file.Name)
+			Expect(file.Modified.Unix()).To(Equal(ReproducibleEpoch.Unix()))
+		}
+		Expect(names).To(Equal([]string{"a.sh", "b.txt", "sub/", "sub/c.txt"}))
+		Expect(reader.File[0].Mode()).To(Equal(os.FileMode(0755)))
+		Expect(reader.File[1].Mode()).To(Equal(os.FileMode(0644)))
+		Expect(reader.File[2].Mode()).To(Equal(os.ModeDir | 0755))
+		Expect(reader.File[3].Mode()).To(Equal(os.FileMode(0644)))
+	})
+	for _, format := range []OutputFormat{ZipFormat{}, TarGzFormat{}, TarZstFormat{}} {
+		format := format
+		It("should create same "+format.Name()+" archive when modification times and permissions change", func() {
+			first := archive(format)
+			touch()
+			Expect(archive(format)).To(Equal(first))
+		})
+	}
+	It("should use time from SOURCE_DATE_EPOCH as modification time", func() {
+		os.Setenv("SOURCE_DATE_EPOCH", "1500000000")
+		b := archive(ZipFormat{})
+		reader, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		Expect(err).NotTo(HaveOccurred())
+		for _, file := range reader.File {
+			Expect(file.Modified.Unix()).To(Equal(int64(1500000000)))
+		}
+	})
+	It("should give a full signature which doesn't change when modification times change", func() {
+		session := NewSession(NewSource(appDir), &LocalHandler{})
+		session.SetReproducible(true)
+		Expect(session.SetSignatureStrategy(FullSignature{})).To(Succeed())
+		first, err := session.Sha1()
+		Expect(err).NotTo(HaveOccurred())
+		touch()
+		second, err := session.Sha1()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second).To(Equal(first))
+	})
+})
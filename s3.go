@@ -0,0 +1,455 @@
+package zipper
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3DefaultEndpoint = "https://s3.amazonaws.com"
+	s3DefaultRegion   = "us-east-1"
+	s3EmptyPayload    = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	s3TimeFormat      = "20060102T150405Z"
+	s3DateFormat      = "20060102"
+)
+
+// Credentials and location of an s3 compatible api
+type S3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Region used to sign requests (default to us-east-1)
+	Region string
+	// Endpoint of the s3 api, e.g.: https://minio.local:9000 (default to https://s3.amazonaws.com)
+	// Requests are always made in path style (i.e.: https://endpoint/bucket/key)
+	Endpoint string
+}
+
+// Retrieve s3 credentials from environment variables
+// (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, AWS_REGION or AWS_DEFAULT_REGION and AWS_ENDPOINT_URL)
+func S3CredentialsFromEnv() *S3Credentials {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	return &S3Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Region:          region,
+		Endpoint:        os.Getenv("AWS_ENDPOINT_URL"),
+	}
+}
+
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+}
+
+type s3ListResult struct {
+	Contents              []s3Object `xml:"Contents"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+}
+
+type S3Handler struct {
+}
+
+func (h S3Handler) Zip(src *Source) (ZipReadCloser, error) {
+	bucket, key, err := h.parsePath(src.Path)
+	if err != nil {
+		return nil, err
+	}
+	if h.isPrefix(key) {
+		return h.prefixToZip(src, bucket, key)
+	}
+	var resp *http.Response
+	processor := NewCompressProcessor(src, func(src *Source) (io.ReadCloser, int64, string, error) {
+		resp, err = h.doRequest(src, "GET", bucket, key, nil)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		lastModified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		return modTimeReadCloser{resp.Body, lastModified}, resp.ContentLength, key, nil
+	})
+	return processor.ToZipOr(func(reader io.ReadCloser, size int64, _ string) (ZipReadCloser, error) {
+		return h.objectToZip(src, reader, size, key, h.lastModified(resp))
+	})
+}
+
+func (h S3Handler) objectToZip(src *Source, body io.ReadCloser, size int64, key string, modTime time.Time) (ZipReadCloser, error) {
+	reader := bufio.NewReader(body)
+	header, _ := reader.Peek(4)
+	fh := &zip.FileHeader{
+		Name:   path.Base(key),
+		Method: zip.Deflate,
+	}
+	fh.SetModTime(modTime)
+	if IsExecutable(bytes.NewReader(header)) {
+		fh.SetMode(0755)
+	} else {
+		fh.SetMode(0644)
+	}
+	if size != UnknownSize {
+		fh.UncompressedSize64 = uint64(size)
+	}
+	return makeArchiveFile(src, "s3-zipper", func(zipWriter EntryWriter) error {
+		w, err := zipWriter.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, reader)
+		return err
+	}, body.Close)
+}
+
+func (h S3Handler) prefixToZip(src *Source, bucket, prefix string) (ZipReadCloser, error) {
+	objects, err := h.listObjects(src, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("s3://%s/%s is empty", bucket, prefix)
+	}
+	filter := CtxPathFilter(src)
+	return makeArchiveFile(src, "s3-zipper", func(zipWriter EntryWriter) error {
+		dirs := make(map[string]bool)
+		for _, object := range objects {
+			name := strings.TrimPrefix(object.Key, prefix)
+			if name == "" || !filter.Match(name, strings.HasSuffix(name, "/")) {
+				continue
+			}
+			err := h.writeParentDirs(zipWriter, dirs, name, object.LastModified)
+			if err != nil {
+				return err
+			}
+			if strings.HasSuffix(name, "/") {
+				continue
+			}
+			err = h.writeObject(src, zipWriter, bucket, object, name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil)
+}
+
+func (h S3Handler) writeParentDirs(zipWriter EntryWriter, dirs map[string]bool, name string, modTime time.Time) error {
+	parts := strings.Split(strings.TrimSuffix(name, "/"), "/")
+	if !strings.HasSuffix(name, "/") {
+		parts = parts[:len(parts)-1]
+	}
+	for i := range parts {
+		dir := strings.Join(parts[:i+1], "/") + "/"
+		if dirs[dir] {
+			continue
+		}
+		dirs[dir] = true
+		fh := &zip.FileHeader{
+			Name: dir,
+		}
+		fh.SetModTime(modTime)
+		fh.SetMode(os.ModeDir | 0755)
+		_, err := zipWriter.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h S3Handler) writeObject(src *Source, zipWriter EntryWriter, bucket string, object s3Object, name string) error {
+	resp, err := h.doRequest(src, "GET", bucket, object.Key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	header, _ := reader.Peek(4)
+	fh := &zip.FileHeader{
+		Name:   name,
+		Method: zip.Deflate,
+	}
+	fh.SetModTime(object.LastModified)
+	if IsExecutable(bytes.NewReader(header)) {
+		fh.SetMode(0755)
+	} else {
+		fh.SetMode(0644)
+	}
+	if resp.ContentLength >= 0 {
+		fh.UncompressedSize64 = uint64(resp.ContentLength)
+	}
+	w, err := zipWriter.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, reader)
+	return err
+}
+
+func (h S3Handler) lastModified(resp *http.Response) time.Time {
+	lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return ReproducibleEpoch
+	}
+	return lastModified
+}
+
+func (h S3Handler) listObjects(src *Source, bucket, prefix string) ([]s3Object, error) {
+	objects := make([]s3Object, 0)
+	continuationToken := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		resp, err := h.doRequest(src, "GET", bucket, "", query)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		continuationToken = result.NextContinuationToken
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects, nil
+}
+
+func (h S3Handler) doRequest(src *Source, method, bucket, key string, query url.Values) (*http.Response, error) {
+	creds := CtxS3Credentials(src)
+	if creds == nil {
+		creds = S3CredentialsFromEnv()
+	}
+	endpoint := creds.Endpoint
+	if endpoint == "" {
+		endpoint = s3DefaultEndpoint
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	u.Path = u.Path + "/" + bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	if query != nil {
+		u.RawQuery = s3CanonicalQuery(query)
+	}
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(src.Context())
+	if creds.AccessKeyID != "" {
+		s3SignRequest(req, creds, time.Now().UTC())
+	}
+	client := CtxHttpClient(src)
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	err = HttpHandler{}.checkRespHttpError(resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (h S3Handler) parsePath(p string) (string, string, error) {
+	u, err := url.Parse(p)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Host == "" {
+		return "", "", fmt.Errorf("Bucket must be set in s3 path '%s'", p)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+func (h S3Handler) isPrefix(key string) bool {
+	return key == "" || strings.HasSuffix(key, "/")
+}
+
+func (h S3Handler) Detect(src *Source) bool {
+	return strings.HasPrefix(src.Path, "s3://")
+}
+
+// Create signature from ETag and Last-Modified of the object (or of every objects under prefix)
+// This not download any objects
+func (h S3Handler) Sha1(src *Source) (string, error) {
+	bucket, key, err := h.parsePath(src.Path)
+	if err != nil {
+		return "", err
+	}
+	hash := sha1.New()
+	if h.isPrefix(key) {
+		objects, err := h.listObjects(src, bucket, key)
+		if err != nil {
+			return "", err
+		}
+		for _, object := range objects {
+			fmt.Fprintf(hash, "%s\n%s\n%s\n", object.Key, object.ETag, object.LastModified.UTC().Format(time.RFC3339))
+		}
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+	resp, err := h.doRequest(src, "HEAD", bucket, key, nil)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	fmt.Fprintf(hash, "%s\n%s\n%s\n", key, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"))
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Same as Sha1, signature is already made from metadata
+func (h S3Handler) MetadataSha1(src *Source) (string, error) {
+	return h.Sha1(src)
+}
+
+func (h S3Handler) SignatureStrategies() []string {
+	return []string{SignatureFast, SignatureFull, SignatureMetadata}
+}
+
+func (h S3Handler) Name() string {
+	return "s3"
+}
+
+// sign a request with aws signature v4
+func s3SignRequest(req *http.Request, creds *S3Credentials, now time.Time) {
+	region := creds.Region
+	if region == "" {
+		region = s3DefaultRegion
+	}
+	amzDate := now.Format(s3TimeFormat)
+	scope := strings.Join([]string{now.Format(s3DateFormat), region, "s3", "aws4_request"}, "/")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3EmptyPayload)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-date":           amzDate,
+		"x-amz-content-sha256": s3EmptyPayload,
+	}
+	if creds.SessionToken != "" {
+		headers["x-amz-security-token"] = creds.SessionToken
+	}
+	headerNames := make([]string, 0, len(headers))
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	canonicalHeaders := ""
+	for _, name := range headerNames {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		s3EmptyPayload,
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	key := s3Hmac([]byte("AWS4"+creds.SecretAccessKey), now.Format(s3DateFormat))
+	key = s3Hmac(key, region)
+	key = s3Hmac(key, "s3")
+	key = s3Hmac(key, "aws4_request")
+	signature := hex.EncodeToString(s3Hmac(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func s3Hmac(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escape a path as aws signature v4 expect it (rfc 3986 without escaping slashes)
+func s3EscapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = s3Escape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// create a query sorted by keys and escaped as aws signature v4 expect it
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3Escape(s string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(s) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' {
+			escaped.WriteByte(b)
+			continue
+		}
+		fmt.Fprintf(&escaped, "%%%02X", b)
+	}
+	return escaped.String()
+}
@@ -0,0 +1,287 @@
+package zipper_test
+
+import (
+	. "github.com/ArthurHlt/zipper"
+
+	"archive/zip"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type fakeS3Object struct {
+	Key          string
+	LastModified time.Time
+	ETag         string
+	Size         int64
+}
+
+type fakeS3ListResult struct {
+	XMLName  xml.Name `xml:"ListBucketResult"`
+	Contents []fakeS3Object
+}
+
+// Serve a subset of the s3 rest api (GET and HEAD object, list objects v2) from local files
+type FakeS3Handler struct {
+	bucket       string
+	objects      map[string]string
+	lastModified time.Time
+	check        func(req *http.Request)
+}
+
+func (h *FakeS3Handler) etag(key string) string {
+	b, err := ioutil.ReadFile(h.objects[key])
+	if err != nil {
+		panic(err)
+	}
+	sum := md5.Sum(b)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (h *FakeS3Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.check != nil {
+		h.check(req)
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/")
+	if path == h.bucket && req.URL.Query().Get("list-type") == "2" {
+		prefix := req.URL.Query().Get("prefix")
+		keys := make([]string, 0)
+		for key := range h.objects {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		result := fakeS3ListResult{}
+		for _, key := range keys {
+			stat, _ := os.Stat(h.objects[key])
+			result.Contents = append(result.Contents, fakeS3Object{
+				Key:          key,
+				LastModified: h.lastModified,
+				ETag:         h.etag(key),
+				Size:         stat.Size(),
+			})
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(result)
+		return
+	}
+	key := strings.TrimPrefix(path, h.bucket+"/")
+	if _, ok := h.objects[key]; !ok || !strings.HasPrefix(path, h.bucket+"/") {
+		w.WriteHeader(404)
+		w.Write([]byte("<Error><Code>NoSuchKey</Code></Error>"))
+		return
+	}
+	f, err := os.Open(h.objects[key])
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	stat, _ := f.Stat()
+	w.Header().Set("ETag", h.etag(key))
+	if !h.lastModified.IsZero() {
+		w.Header().Set("Last-Modified", h.lastModified.Format(http.TimeFormat))
+	}
+	w.Header().Set("Content-Length", fmt.Sprint(stat.Size()))
+	w.WriteHeader(200)
+	if req.Method == "HEAD" {
+		return
+	}
+	io.Copy(w, f)
+}
+
+var _ = Describe("S3", func() {
+	var handler S3Handler
+	var server *httptest.Server
+	var s3Handler *FakeS3Handler
+	var creds *S3Credentials
+
+	BeforeEach(func() {
+		handler = S3Handler{}
+		workingDir, err := os.Getwd()
+		Expect(err).NotTo(HaveOccurred())
+		appDir := filepath.Join(workingDir, "fixtures", "applications")
+
+		s3Handler = &FakeS3Handler{
+			bucket: "bucket",
+			objects: map[string]string{
+				"final.zip":                    filepath.Join(appDir, "final.zip"),
+				"final.tar.gz":                 filepath.Join(appDir, "final.tar.gz"),
+				"executable":                   filepath.Join(appDir, "executable"),
+				"app/dir1/file1.txt":           filepath.Join(appDir, "app-copy-test", "dir1", "file1.txt"),
+				"app/dir1/child-dir/file2.txt": filepath.Join(appDir, "app-copy-test", "dir1", "child-dir", "file2.txt"),
+				"app/dir2/child-dir2/grandchild-dir2/file4.txt": filepath.Join(appDir, "app-copy-test", "dir2", "child-dir2", "grandchild-dir2", "file4.txt"),
+			},
+			lastModified: time.Date(2019, 8, 9, 0, 0, 0, 0, time.UTC),
+		}
+		server = httptest.NewServer(s3Handler)
+		creds = &S3Credentials{
+			AccessKeyID:     "AKID",
+			SecretAccessKey: "SECRET",
+			Endpoint:        server.URL,
+		}
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+	newSource := func(path string) *Source {
+		src := NewSource(path)
+		SetCtxHttpClient(src, server.Client())
+		SetCtxS3Credentials(src, creds)
+		return src
+	}
+	Describe("Detect", func() {
+		It("should return true when path use s3 scheme", func() {
+			Expect(handler.Detect(NewSource("s3://bucket/key"))).Should(BeTrue())
+			Expect(handler.Detect(NewSource("s3://bucket/prefix/"))).Should(BeTrue())
+		})
+		It("should return false when path doesn't use s3 scheme", func() {
+			Expect(handler.Detect(NewSource("http://bucket/key"))).Should(BeFalse())
+		})
+	})
+	Describe("Zip", func() {
+		It("should sign requests with given credentials", func() {
+			ran := false
+			s3Handler.check = func(req *http.Request) {
+				defer GinkgoRecover()
+				ran = true
+				Expect(req.Header.Get("Authorization")).To(HavePrefix("AWS4-HMAC-SHA256 Credential=AKID/"))
+				Expect(req.Header.Get("X-Amz-Date")).ToNot(BeEmpty())
+			}
+			zipFile, err := handler.Zip(newSource("s3://bucket/final.zip"))
+			Expect(err).NotTo(HaveOccurred())
+			defer zipFile.Close()
+
+			checkZipFile(zipFile)
+			Expect(ran).To(BeTrue())
+		})
+		It("should create zip file from a tgz object", func() {
+			zipFile, err := handler.Zip(newSource("s3://bucket/final.tar.gz"))
+			Expect(err).NotTo(HaveOccurred())
+			defer zipFile.Close()
+
+			checkZipFile(zipFile)
+		})
+		It("should create zip file containing every objects under a prefix", func() {
+			zipFile, err := handler.Zip(newSource("s3://bucket/app/"))
+			Expect(err).NotTo(HaveOccurred())
+			defer zipFile.Close()
+
+			b, err := ioutil.ReadAll(zipFile)
+			Expect(err).NotTo(HaveOccurred())
+			reader, err := zip.NewReader(strings.NewReader(string(b)), int64(len(b)))
+			Expect(err).NotTo(HaveOccurred())
+
+			names := make([]string, 0)
+			for _, file := range reader.File {
+				names = append(names, file.Name)
+			}
+			Expect(names).To(Equal([]string{
+				"dir1/",
+				"dir1/child-dir/",
+				"dir1/child-dir/file2.txt",
+				"dir1/file1.txt",
+				"dir2/",
+				"dir2/child-dir2/",
+				"dir2/child-dir2/grandchild-dir2/",
+				"dir2/child-dir2/grandchild-dir2/file4.txt",
+			}))
+		})
+		It("should only put objects under a prefix which are included and not excluded", func() {
+			src := newSource("s3://bucket/app/")
+			SetCtxIncludes(src, []string{"dir1/"})
+			SetCtxExcludes(src, []string{"child-dir/"})
+			zipFile, err := handler.Zip(src)
+			Expect(err).NotTo(HaveOccurred())
+			defer zipFile.Close()
+
+			b, err := ioutil.ReadAll(zipFile)
+			Expect(err).NotTo(HaveOccurred())
+			reader, err := zip.NewReader(strings.NewReader(string(b)), int64(len(b)))
+			Expect(err).NotTo(HaveOccurred())
+
+			names := make([]string, 0)
+			for _, file := range reader.File {
+				names = append(names, file.Name)
+			}
+			Expect(names).To(Equal([]string{
+				"dir1/",
+				"dir1/file1.txt",
+			}))
+		})
+		Context("when not a zip or tar or tgz", func() {
+			It("should create a zip which contain this object and set exec permission when it's an executable", func() {
+				zipFile, err := handler.Zip(newSource("s3://bucket/executable"))
+				Expect(err).NotTo(HaveOccurred())
+				defer zipFile.Close()
+
+				b, err := ioutil.ReadAll(zipFile)
+				Expect(err).NotTo(HaveOccurred())
+				reader, err := zip.NewReader(strings.NewReader(string(b)), int64(len(b)))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(reader.File).To(HaveLen(1))
+				Expect(reader.File[0].Name).To(Equal("executable"))
+				Expect(reader.File[0].Mode()).To(Equal(os.FileMode(0755)))
+				Expect(reader.File[0].Modified.UTC()).To(Equal(s3Handler.lastModified))
+			})
+			It("should give a fixed modification time to this object when server doesn't give Last-Modified header", func() {
+				s3Handler.lastModified = time.Time{}
+				zipFile, err := handler.Zip(newSource("s3://bucket/executable"))
+				Expect(err).NotTo(HaveOccurred())
+				defer zipFile.Close()
+
+				b, err := ioutil.ReadAll(zipFile)
+				Expect(err).NotTo(HaveOccurred())
+				reader, err := zip.NewReader(strings.NewReader(string(b)), int64(len(b)))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(reader.File).To(HaveLen(1))
+				Expect(reader.File[0].Modified.UTC()).To(Equal(ReproducibleEpoch))
+			})
+		})
+		It("should return an error when object doesn't exist", func() {
+			_, err := handler.Zip(newSource("s3://bucket/notexists"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("NoSuchKey"))
+		})
+	})
+	Describe("Sha1", func() {
+		It("should create sha1 from object metadata without downloading it", func() {
+			s3Handler.check = func(req *http.Request) {
+				defer GinkgoRecover()
+				Expect(req.Method).To(Equal("HEAD"))
+			}
+			sha1, err := handler.Sha1(newSource("s3://bucket/final.zip"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sha1).ShouldNot(BeEmpty())
+
+			s3Handler.lastModified = s3Handler.lastModified.Add(time.Hour)
+			newSha1, err := handler.Sha1(newSource("s3://bucket/final.zip"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newSha1).ShouldNot(Equal(sha1))
+		})
+		It("should create sha1 from metadata of every objects under a prefix", func() {
+			sha1, err := handler.Sha1(newSource("s3://bucket/app/"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sha1).ShouldNot(BeEmpty())
+
+			delete(s3Handler.objects, "app/dir1/file1.txt")
+			newSha1, err := handler.Sha1(newSource("s3://bucket/app/"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newSha1).ShouldNot(Equal(sha1))
+		})
+	})
+})
@@ -1,28 +1,104 @@
 package zipper
 
+import (
+	"context"
+	"fmt"
+)
+
 type Session struct {
-	handler Handler
-	src     *Source
+	handler   Handler
+	src       *Source
+	signature SignatureStrategy
+	format    OutputFormat
 }
 
-// Create a new session
+// Create a new session, signature is made with fast signature strategy by default
+// and archive is created in zip format by default
 func NewSession(src *Source, handler Handler) *Session {
-	return &Session{handler, src}
+	return &Session{
+		handler:   handler,
+		src:       src,
+		signature: FastSignature{},
+		format:    ZipFormat{},
+	}
 }
 
 // Create zip file
 func (s Session) Zip() (ZipReadCloser, error) {
-	return s.handler.Zip(s.src)
+	return handlerZip(s.handler, s.src)
+}
+
+// Create zip file, creation is aborted when context is cancelled
+func (s Session) ZipContext(ctx context.Context) (ZipReadCloser, error) {
+	return handlerZip(s.handler, s.src.WithCancelContext(ctx))
 }
 
-// Retrieve signature
+// Create archive in session output format
+func (s Session) Archive() (ZipReadCloser, error) {
+	return s.archive(s.src)
+}
+
+// Create archive in session output format, creation is aborted when context is cancelled
+func (s Session) ArchiveContext(ctx context.Context) (ZipReadCloser, error) {
+	return s.archive(s.src.WithCancelContext(ctx))
+}
+
+// handlers write entries straight in session output format when they can, zip is converted otherwise
+func (s Session) archive(src *Source) (ZipReadCloser, error) {
+	src = src.WithContext(src.Context())
+	SetCtxOutputFormat(src, s.format)
+	zipFile, err := handlerZip(s.handler, src)
+	if err != nil {
+		return nil, err
+	}
+	return NewArchive(src, zipFile, s.format)
+}
+
+// Set output format to use for creating archive (zip, tar, tar.gz, tar.zst, ...)
+func (s *Session) SetOutputFormat(format OutputFormat) {
+	s.format = format
+}
+
+// Retrieve output format use in the session
+func (s Session) OutputFormat() OutputFormat {
+	return s.format
+}
+
+// Retrieve signature made with session signature strategy
 func (s Session) Sha1() (string, error) {
-	return s.handler.Sha1(s.src)
+	return s.signature.Signature(s.handler, s.src)
+}
+
+// Retrieve signature made with session signature strategy, retrieving is aborted when context is cancelled
+func (s Session) Sha1Context(ctx context.Context) (string, error) {
+	return s.signature.Signature(s.handler, s.src.WithCancelContext(ctx))
+}
+
+// Set signature strategy to use for creating signature
+// This return an error if session handler doesn't support this strategy
+func (s *Session) SetSignatureStrategy(strategy SignatureStrategy) error {
+	if !SupportsSignatureStrategy(s.handler, strategy) {
+		return fmt.Errorf("Handler %s doesn't support %s signature", s.handler.Name(), strategy.Name())
+	}
+	s.signature = strategy
+	return nil
+}
+
+// Retrieve signature strategy use in the session
+func (s Session) SignatureStrategy() SignatureStrategy {
+	return s.signature
 }
 
 // Check if source signature is different from a previous signature
 // If true, it's mean than files have changed
 func (s Session) IsDiff(storedSha1 string) (bool, string, error) {
+	name := s.signature.Name()
+	if checker, ok := s.handler.(DiffChecker); ok && (name == SignatureFast || name == SignatureMetadata) {
+		diff, sha1Given, handled, err := checker.IsDiff(s.src, storedSha1)
+		if handled || err != nil {
+			return diff, sha1Given, err
+		}
+	}
 	sha1Given, err := s.Sha1()
 	if err != nil {
 		return true, "", err
@@ -30,6 +106,82 @@ func (s Session) IsDiff(storedSha1 string) (bool, string, error) {
 	return storedSha1 != sha1Given, sha1Given, nil
 }
 
+// Set to true to stream zip while it's read instead of buffering it in a temp file
+// Zip size will be unknown in this case (ZipReadCloser.Size() will return UnknownSize)
+func (s Session) SetStreaming(streaming bool) {
+	SetCtxStreaming(s.src, streaming)
+}
+
+// Set to true to create byte-for-byte reproducible zip (see NewReproducibleZip),
+// signature made with full signature strategy is also made from the reproducible zip
+func (s Session) SetReproducible(reproducible bool) {
+	SetCtxReproducible(s.src, reproducible)
+}
+
+// Set patterns, in .gitignore format, of paths to include in zip
+// When set, only paths matching one of them (or inside a matching directory) are kept
+func (s Session) SetIncludes(patterns ...string) {
+	SetCtxIncludes(s.src, patterns)
+}
+
+// Set patterns, in .gitignore format, of paths to exclude from zip
+// Paths matching one of them are removed, even when they are included
+func (s Session) SetExcludes(patterns ...string) {
+	SetCtxExcludes(s.src, patterns)
+}
+
+// Set to true to honour ignore files (.zipignore, ...) found inside an archive source (zip, tar, ...)
+// as they are for a directory, a zip source is then rewritten instead of being given as it is
+func (s Session) SetArchiveIgnore(archiveIgnore bool) {
+	SetCtxArchiveIgnore(s.src, archiveIgnore)
+}
+
+// Set directory where git repositories are kept as bare mirrors between calls, only new objects are then fetched
+// Cache is disabled when dir is empty
+func (s Session) SetGitCacheDir(dir string) {
+	SetCtxGitCacheDir(s.src, dir)
+}
+
+// Set a provider of credentials for git and http urls without credentials inside them,
+// it's used before default providers (environment, netrc file and git credential helpers)
+func (s Session) SetCredentialProvider(provider CredentialProvider) {
+	SetCtxCredentialProvider(s.src, provider)
+}
+
+// Set to true to send credentials from default providers (environment, netrc file and git credential helpers)
+// to plain http urls, they are only sent to https urls otherwise
+func (s Session) SetPlainHttpCredentials(allow bool) {
+	SetCtxPlainHttpCredentials(s.src, allow)
+}
+
+// Set options for git repositories reached over ssh: known hosts files, host key fingerprints, insecure mode
+// and private key in PEM format
+func (s Session) SetGitSshOptions(options *GitSshOptions) {
+	SetCtxGitSshOptions(s.src, options)
+}
+
+// Set to true to clone git submodules at their pinned commit, recursively
+// It can also be set on a git url with submodules query parameter (e.g.: https://github.com/ArthurHlt/zipper.git?submodules=true)
+func (s Session) SetGitSubmodules(submodules bool) {
+	SetCtxGitSubmodules(s.src, submodules)
+}
+
+// Set to true to replace git lfs pointer files by their content retrieved from git lfs server
+// It can also be set on a git url with lfs query parameter (e.g.: https://github.com/ArthurHlt/zipper.git?lfs=true)
+func (s Session) SetGitLfs(lfs bool) {
+	SetCtxGitLfs(s.src, lfs)
+}
+
+// Set to true to honour .gitignore files and .git/info/exclude in addition to .cfignore, .cloudignore and .zipignore files
+func (s Session) SetGitIgnore(gitIgnore bool) {
+	SetCtxGitIgnore(s.src, gitIgnore)
+}
+
+// Set how symlinks found in directories and tarballs are handled
+func (s Session) SetSymlinkMode(mode SymlinkMode) {
+	SetCtxSymlinkMode(s.src, mode)
+}
+
 // Retrieve handler use in the session
 func (s Session) Handler() Handler {
 	return s.handler
@@ -3,11 +3,37 @@ package zipper_test
 import (
 	. "github.com/ArthurHlt/zipper"
 
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
 	"github.com/ArthurHlt/zipper/zipperfakes"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
+// Signature strategy prefixing fast signature, restricted to handlers with handlerName when set
+type prefixSignature struct {
+	handlerName string
+}
+
+func (s prefixSignature) Signature(handler Handler, src *Source) (string, error) {
+	sha1, err := handler.Sha1(src)
+	if err != nil {
+		return "", err
+	}
+	return "prefix-" + sha1, nil
+}
+
+func (s prefixSignature) Name() string {
+	return "prefix"
+}
+
+func (s prefixSignature) SupportsHandler(handler Handler) bool {
+	return s.handlerName == "" || s.handlerName == handler.Name()
+}
+
 var _ = Describe("Session", func() {
 	var session *Session
 	BeforeEach(func() {
@@ -15,8 +41,58 @@ var _ = Describe("Session", func() {
 		h1.Sha1Stub = func(src *Source) (string, error) {
 			return src.Path, nil
 		}
+		h1.ZipStub = func(src *Source) (ZipReadCloser, error) {
+			return NewZipFile(ioutil.NopCloser(strings.NewReader("zip content")), 11, func() error {
+				return nil
+			}), nil
+		}
 		session = NewSession(NewSource("apath"), h1)
 	})
+	Describe("Sha1Context", func() {
+		It("should give a source cancelled by context but with values kept", func() {
+			h1 := &zipperfakes.FakeHandler{}
+			h1.Sha1Stub = func(src *Source) (string, error) {
+				Expect(CtxHttpClient(src)).To(Equal(http.DefaultClient))
+				return "", src.Context().Err()
+			}
+			src := NewSource("apath")
+			SetCtxHttpClient(src, http.DefaultClient)
+			session = NewSession(src, h1)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := session.Sha1Context(ctx)
+			Expect(err).To(Equal(context.Canceled))
+		})
+	})
+	Describe("SetSignatureStrategy", func() {
+		It("should use signature strategy to retrieve signature", func() {
+			err := session.SetSignatureStrategy(FullSignature{})
+			Expect(err).ToNot(HaveOccurred())
+
+			sha1, err := session.Sha1()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sha1).ShouldNot(Equal("apath"))
+		})
+		It("should return an error when handler doesn't support signature strategy", func() {
+			err := session.SetSignatureStrategy(ContentSignature{})
+			Expect(err).To(HaveOccurred())
+			Expect(session.SignatureStrategy().Name()).Should(Equal(SignatureFast))
+		})
+		It("should accept custom signature strategy", func() {
+			err := session.SetSignatureStrategy(prefixSignature{})
+			Expect(err).ToNot(HaveOccurred())
+
+			sha1, err := session.Sha1()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sha1).Should(Equal("prefix-apath"))
+		})
+		It("should ask custom signature strategy if it supports handler", func() {
+			err := session.SetSignatureStrategy(prefixSignature{handlerName: "other"})
+			Expect(err).To(HaveOccurred())
+			Expect(session.SignatureStrategy().Name()).Should(Equal(SignatureFast))
+		})
+	})
 	Describe("IsDiff", func() {
 		It("should find no diff when sha1 match", func() {
 			diff, _, err := session.IsDiff("apath")
@@ -0,0 +1,158 @@
+package zipper
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+const (
+	// Signature made by handler Sha1 function (often from a small chunk of the zip)
+	SignatureFast = "fast"
+	// Signature made from the entire produced zip
+	SignatureFull = "full"
+	// Signature made from source metadata (etag, last-modified, commit, ...)
+	SignatureMetadata = "metadata"
+	// Signature made from relative path, mode and content of every files
+	SignatureContent = "content"
+)
+
+// Strategy to create a signature from a source with a handler
+type SignatureStrategy interface {
+	Signature(handler Handler, src *Source) (string, error)
+	Name() string
+}
+
+// Signature strategy which declares handlers it can be used with (e.g.: handlers implementing an interface it needs)
+// Custom signature strategy which doesn't implement it is supported by every handlers
+type HandlerSignatureStrategy interface {
+	SignatureStrategy
+	SupportsHandler(handler Handler) bool
+}
+
+// Handler which declares built-in signature strategies it supports by their names
+// Handler which doesn't implement it supports fast and full signature strategies
+type SignatureStrategiesHandler interface {
+	SignatureStrategies() []string
+}
+
+// Handler which can create a signature from source metadata (etag, last-modified, commit, ...)
+type MetadataSigner interface {
+	MetadataSha1(src *Source) (string, error)
+}
+
+// Handler which can create a signature from relative path, mode and content of every files
+type ContentSigner interface {
+	ContentSha1(src *Source) (string, error)
+}
+
+// Handler which can check if source changed from a signature made with fast or metadata signature strategy
+// without recreating this signature (e.g.: with http conditional requests)
+type DiffChecker interface {
+	// handled is false when handler can't do the check from this signature,
+	// in this case signatures are compared
+	IsDiff(src *Source, storedSha1 string) (diff bool, sha1 string, handled bool, err error)
+}
+
+// Retrieve names of signature strategies supported by a handler
+func SupportedSignatureStrategies(handler Handler) []string {
+	if sh, ok := handler.(SignatureStrategiesHandler); ok {
+		return sh.SignatureStrategies()
+	}
+	return []string{SignatureFast, SignatureFull}
+}
+
+// Check if a handler supports a signature strategy
+// Built-in strategies must be declared by handler (see SupportedSignatureStrategies), custom ones are supported
+// by every handlers unless they implement HandlerSignatureStrategy
+func SupportsSignatureStrategy(handler Handler, strategy SignatureStrategy) bool {
+	if hs, ok := strategy.(HandlerSignatureStrategy); ok {
+		return hs.SupportsHandler(handler)
+	}
+	if !isBuiltinSignatureStrategy(strategy.Name()) {
+		return true
+	}
+	for _, name := range SupportedSignatureStrategies(handler) {
+		if name == strategy.Name() {
+			return true
+		}
+	}
+	return false
+}
+
+func isBuiltinSignatureStrategy(name string) bool {
+	switch name {
+	case SignatureFast, SignatureFull, SignatureMetadata, SignatureContent:
+		return true
+	}
+	return false
+}
+
+// Signature made by handler Sha1 function
+type FastSignature struct {
+}
+
+func (s FastSignature) Signature(handler Handler, src *Source) (string, error) {
+	return handler.Sha1(src)
+}
+
+func (s FastSignature) Name() string {
+	return SignatureFast
+}
+
+// Signature made from the entire zip produced by handler
+type FullSignature struct {
+}
+
+func (s FullSignature) Signature(handler Handler, src *Source) (string, error) {
+	streamSrc := src.WithContext(src.Context())
+	SetCtxStreaming(streamSrc, true)
+	zipFile, err := handlerZip(handler, streamSrc)
+	if err != nil {
+		return "", err
+	}
+	defer zipFile.Close()
+	h := sha1.New()
+	_, err = io.Copy(h, zipFile)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s FullSignature) Name() string {
+	return SignatureFull
+}
+
+// Signature made from source metadata, handler must implement MetadataSigner
+type MetadataSignature struct {
+}
+
+func (s MetadataSignature) Signature(handler Handler, src *Source) (string, error) {
+	signer, ok := handler.(MetadataSigner)
+	if !ok {
+		return "", fmt.Errorf("Handler %s doesn't support %s signature", handler.Name(), s.Name())
+	}
+	return signer.MetadataSha1(src)
+}
+
+func (s MetadataSignature) Name() string {
+	return SignatureMetadata
+}
+
+// Signature made from relative path, mode and content of every files, handler must implement ContentSigner
+type ContentSignature struct {
+}
+
+func (s ContentSignature) Signature(handler Handler, src *Source) (string, error) {
+	signer, ok := handler.(ContentSigner)
+	if !ok {
+		return "", fmt.Errorf("Handler %s doesn't support %s signature", handler.Name(), s.Name())
+	}
+	return signer.ContentSha1(src)
+}
+
+func (s ContentSignature) Name() string {
+	return SignatureContent
+}
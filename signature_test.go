@@ -0,0 +1,54 @@
+package zipper_test
+
+import (
+	. "github.com/ArthurHlt/zipper"
+
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Signature", func() {
+	var appDir string
+	BeforeEach(func() {
+		workingDir, err := os.Getwd()
+		Expect(err).NotTo(HaveOccurred())
+		appDir = filepath.Join(workingDir, "fixtures", "applications", "app-copy-test")
+	})
+	Describe("SupportedSignatureStrategies", func() {
+		It("should give strategies declared by handler", func() {
+			Expect(SupportedSignatureStrategies(LocalHandler{})).To(Equal([]string{
+				SignatureFast, SignatureFull, SignatureContent,
+			}))
+		})
+	})
+	Describe("FullSignature", func() {
+		It("should give sha1 of the entire zip", func() {
+			sha1, err := FullSignature{}.Signature(LocalHandler{}, NewSource(appDir))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sha1).To(HaveLen(40))
+
+			otherSha1, err := FullSignature{}.Signature(LocalHandler{}, NewSource(appDir))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(otherSha1).To(Equal(sha1))
+		})
+	})
+	Describe("ContentSignature", func() {
+		It("should use content sha1 from handler", func() {
+			sha1, err := ContentSignature{}.Signature(LocalHandler{}, NewSource(appDir))
+			Expect(err).NotTo(HaveOccurred())
+
+			expectedSha1, err := LocalHandler{}.ContentSha1(NewSource(appDir))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sha1).To(Equal(expectedSha1))
+		})
+	})
+	Describe("MetadataSignature", func() {
+		It("should return an error when handler can't create signature from metadata", func() {
+			_, err := MetadataSignature{}.Signature(LocalHandler{}, NewSource(appDir))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -3,14 +3,49 @@ package zipper
 import (
 	"context"
 	"net/http"
+	"time"
+
+	"github.com/ArthurHlt/zipper/dirfiles"
 )
 
 const (
 	HttpClientContextKey SourceContextKey = iota
+	StreamingContextKey
+	S3CredentialsContextKey
+	FormatConvertersContextKey
+	SymlinkModeContextKey
+	ReproducibleContextKey
+	SourceDateContextKey
+	GitIgnoreContextKey
+	IncludesContextKey
+	ExcludesContextKey
+	ArchiveIgnoreContextKey
+	GitCacheDirContextKey
+	GitSubmodulesContextKey
+	GitLfsContextKey
+	CredentialProviderContextKey
+	GitSshOptionsContextKey
+	DecompressorsContextKey
+	OutputFormatContextKey
+	PlainHttpCredentialsContextKey
 )
 
 type SourceContextKey int
 
+// How symlinks found in directories and tarballs are handled
+type SymlinkMode = dirfiles.SymlinkMode
+
+const (
+	// Symlinks are skipped (default)
+	SymlinkSkip = dirfiles.SymlinkSkip
+	// Symlinks are stored as zip entries with symlink mode and their target as content (Info-ZIP convention),
+	// a symlink with an absolute target or a target outside of the source root is refused
+	SymlinkPreserve = dirfiles.SymlinkPreserve
+	// Symlinks are followed and content of their targets is stored,
+	// a symlink resolving outside of the source root or creating a cycle is refused
+	SymlinkFollow = dirfiles.SymlinkFollow
+)
+
 type Source struct {
 	// Path for zip handler
 	Path string
@@ -56,6 +91,31 @@ func (s *Source) WithContext(ctx context.Context) *Source {
 	return s2
 }
 
+// context which is cancelled by its own context but retrieve values
+// from another context when it doesn't have them
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c valuesContext) Value(key interface{}) interface{} {
+	val := c.Context.Value(key)
+	if val != nil {
+		return val
+	}
+	return c.values.Value(key)
+}
+
+// WithCancelContext returns a shallow copy of s with its context
+// cancelled, or reaching its deadline, as ctx does.
+// Contrary to WithContext, values already set in the source context are kept.
+func (s *Source) WithCancelContext(ctx context.Context) *Source {
+	if ctx == nil {
+		panic("nil context")
+	}
+	return s.WithContext(valuesContext{ctx, s.Context()})
+}
+
 // Set http client in the context of a source
 // This could be use for a zip handler
 func SetCtxHttpClient(src *Source, client *http.Client) {
@@ -73,3 +133,360 @@ func CtxHttpClient(src *Source) *http.Client {
 	}
 	return val.(*http.Client)
 }
+
+// Set in the context of a source if zip must be streamed instead of being buffered in a temp file
+// This could be use for a zip handler
+func SetCtxStreaming(src *Source, streaming bool) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, StreamingContextKey, streaming))
+	*src = *ctxValueReq
+}
+
+// Retrieve if zip must be streamed from context
+// This could be use for a zip handler
+func CtxStreaming(src *Source) bool {
+	val := src.Context().Value(StreamingContextKey)
+	if val == nil {
+		return false
+	}
+	return val.(bool)
+}
+
+// Set s3 credentials in the context of a source
+// This could be use for a zip handler
+func SetCtxS3Credentials(src *Source, creds *S3Credentials) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, S3CredentialsContextKey, creds))
+	*src = *ctxValueReq
+}
+
+// Retrieve s3 credentials set in context
+// This could be use for a zip handler
+func CtxS3Credentials(src *Source) *S3Credentials {
+	val := src.Context().Value(S3CredentialsContextKey)
+	if val == nil {
+		return nil
+	}
+	return val.(*S3Credentials)
+}
+
+// Set format converters in the context of a source
+// This could be use for a zip handler
+func SetCtxFormatConverters(src *Source, converters []FormatConverter) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, FormatConvertersContextKey, converters))
+	*src = *ctxValueReq
+}
+
+// Retrieve format converters set in context, default format converters are given when not set
+// This could be use for a zip handler
+func CtxFormatConverters(src *Source) []FormatConverter {
+	val := src.Context().Value(FormatConvertersContextKey)
+	if val == nil {
+		return DefaultFormatConverters()
+	}
+	return val.([]FormatConverter)
+}
+
+// Set how symlinks are handled in the context of a source
+// This could be use for a zip handler
+func SetCtxSymlinkMode(src *Source, mode SymlinkMode) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, SymlinkModeContextKey, mode))
+	*src = *ctxValueReq
+}
+
+// Retrieve how symlinks are handled from context
+// This could be use for a zip handler
+func CtxSymlinkMode(src *Source) SymlinkMode {
+	val := src.Context().Value(SymlinkModeContextKey)
+	if val == nil {
+		return SymlinkSkip
+	}
+	return val.(SymlinkMode)
+}
+
+// Set in the context of a source if zip must be byte-for-byte reproducible (see NewReproducibleZip)
+// This could be use for a zip handler
+func SetCtxReproducible(src *Source, reproducible bool) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, ReproducibleContextKey, reproducible))
+	*src = *ctxValueReq
+}
+
+// Retrieve if zip must be reproducible from context
+// This could be use for a zip handler
+func CtxReproducible(src *Source) bool {
+	val := src.Context().Value(ReproducibleContextKey)
+	if val == nil {
+		return false
+	}
+	return val.(bool)
+}
+
+// Set in the context of a source modification time given to every entries in reproducible mode
+// A zip handler can set it when source has its own date (e.g.: git commit time) and it has not been set yet
+func SetCtxSourceDate(src *Source, date time.Time) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, SourceDateContextKey, date.UTC()))
+	*src = *ctxValueReq
+}
+
+// Retrieve modification time given to every entries in reproducible mode from context,
+// when not set, time from SOURCE_DATE_EPOCH environment variable is given or ReproducibleEpoch if not set
+// This could be use for a zip handler
+func CtxSourceDate(src *Source) time.Time {
+	if date, ok := ctxSourceDate(src); ok {
+		return date
+	}
+	if date, ok := SourceDateEpoch(); ok {
+		return date
+	}
+	return ReproducibleEpoch
+}
+
+// check if source date has been set in context or in SOURCE_DATE_EPOCH environment variable
+func HasCtxSourceDate(src *Source) bool {
+	_, ok := ctxSourceDate(src)
+	if ok {
+		return true
+	}
+	_, ok = SourceDateEpoch()
+	return ok
+}
+
+func ctxSourceDate(src *Source) (time.Time, bool) {
+	val := src.Context().Value(SourceDateContextKey)
+	if val == nil {
+		return time.Time{}, false
+	}
+	return val.(time.Time), true
+}
+
+// Set in the context of a source if .gitignore files and .git/info/exclude must be honoured
+// in addition to .cfignore, .cloudignore and .zipignore files
+// This could be use for a zip handler
+func SetCtxGitIgnore(src *Source, gitIgnore bool) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, GitIgnoreContextKey, gitIgnore))
+	*src = *ctxValueReq
+}
+
+// Retrieve if .gitignore files and .git/info/exclude must be honoured from context
+// This could be use for a zip handler
+func CtxGitIgnore(src *Source) bool {
+	val := src.Context().Value(GitIgnoreContextKey)
+	if val == nil {
+		return false
+	}
+	return val.(bool)
+}
+
+// Set in the context of a source patterns of paths to include, in .gitignore format
+// When set, only paths matching one of them (or inside a matching directory) are kept
+// This could be use for a zip handler
+func SetCtxIncludes(src *Source, patterns []string) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, IncludesContextKey, patterns))
+	*src = *ctxValueReq
+}
+
+// Retrieve patterns of paths to include from context
+// This could be use for a zip handler
+func CtxIncludes(src *Source) []string {
+	val := src.Context().Value(IncludesContextKey)
+	if val == nil {
+		return nil
+	}
+	return val.([]string)
+}
+
+// Set in the context of a source patterns of paths to exclude, in .gitignore format
+// Paths matching one of them are removed, even when they are included
+// This could be use for a zip handler
+func SetCtxExcludes(src *Source, patterns []string) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, ExcludesContextKey, patterns))
+	*src = *ctxValueReq
+}
+
+// Retrieve patterns of paths to exclude from context
+// This could be use for a zip handler
+func CtxExcludes(src *Source) []string {
+	val := src.Context().Value(ExcludesContextKey)
+	if val == nil {
+		return nil
+	}
+	return val.([]string)
+}
+
+// Retrieve filter made from include and exclude patterns set in context, it's nil when there is no patterns
+// This could be use for a zip handler
+func CtxPathFilter(src *Source) *dirfiles.PathFilter {
+	return dirfiles.NewPathFilter(CtxIncludes(src), CtxExcludes(src))
+}
+
+// Set in the context of a source if ignore files found inside an archive (zip, tar, ...) must be honoured
+// as they are for a directory, zip is then rewritten instead of being given as it is
+// This could be use for a zip handler
+func SetCtxArchiveIgnore(src *Source, archiveIgnore bool) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, ArchiveIgnoreContextKey, archiveIgnore))
+	*src = *ctxValueReq
+}
+
+// Retrieve if ignore files found inside an archive must be honoured from context
+// This could be use for a zip handler
+func CtxArchiveIgnore(src *Source) bool {
+	val := src.Context().Value(ArchiveIgnoreContextKey)
+	if val == nil {
+		return false
+	}
+	return val.(bool)
+}
+
+// Set in the context of a source the directory where git handler keeps bare mirrors of remotes between calls
+// This could be use for a zip handler
+func SetCtxGitCacheDir(src *Source, dir string) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, GitCacheDirContextKey, dir))
+	*src = *ctxValueReq
+}
+
+// Retrieve directory where git handler keeps bare mirrors of remotes from context, it's empty when there is no cache
+// This could be use for a zip handler
+func CtxGitCacheDir(src *Source) string {
+	val := src.Context().Value(GitCacheDirContextKey)
+	if val == nil {
+		return ""
+	}
+	return val.(string)
+}
+
+// Set in the context of a source if git submodules must be cloned at their pinned commit
+// This could be use for a zip handler
+func SetCtxGitSubmodules(src *Source, submodules bool) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, GitSubmodulesContextKey, submodules))
+	*src = *ctxValueReq
+}
+
+// Retrieve if git submodules must be cloned from context
+// This could be use for a zip handler
+func CtxGitSubmodules(src *Source) bool {
+	val := src.Context().Value(GitSubmodulesContextKey)
+	if val == nil {
+		return false
+	}
+	return val.(bool)
+}
+
+// Set in the context of a source if git lfs pointer files must be replaced by their content
+// This could be use for a zip handler
+func SetCtxGitLfs(src *Source, lfs bool) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, GitLfsContextKey, lfs))
+	*src = *ctxValueReq
+}
+
+// Retrieve if git lfs pointer files must be replaced by their content from context
+// This could be use for a zip handler
+func CtxGitLfs(src *Source) bool {
+	val := src.Context().Value(GitLfsContextKey)
+	if val == nil {
+		return false
+	}
+	return val.(bool)
+}
+
+// Set in the context of a source a provider of credentials for urls without credentials inside them,
+// it's used before default providers (environment, netrc file and git credential helpers)
+// This could be use for a zip handler
+func SetCtxCredentialProvider(src *Source, provider CredentialProvider) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, CredentialProviderContextKey, provider))
+	*src = *ctxValueReq
+}
+
+// Retrieve provider of credentials from context, it's nil when not set
+// This could be use for a zip handler
+func CtxCredentialProvider(src *Source) CredentialProvider {
+	val := src.Context().Value(CredentialProviderContextKey)
+	if val == nil {
+		return nil
+	}
+	return val.(CredentialProvider)
+}
+
+// Set in the context of a source options for git repositories reached over ssh (known hosts, host key fingerprints, private key)
+// This could be use for a zip handler
+func SetCtxGitSshOptions(src *Source, options *GitSshOptions) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, GitSshOptionsContextKey, options))
+	*src = *ctxValueReq
+}
+
+// Retrieve options for git repositories reached over ssh from context, it's nil when not set
+// This could be use for a zip handler
+func CtxGitSshOptions(src *Source) *GitSshOptions {
+	val := src.Context().Value(GitSshOptionsContextKey)
+	if val == nil {
+		return nil
+	}
+	return val.(*GitSshOptions)
+}
+
+// Set decompressors in the context of a source
+// This could be use for a zip handler
+func SetCtxDecompressors(src *Source, decompressors []Decompressor) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, DecompressorsContextKey, decompressors))
+	*src = *ctxValueReq
+}
+
+// Retrieve decompressors set in context, default decompressors are given when not set
+// This could be use for a zip handler
+func CtxDecompressors(src *Source) []Decompressor {
+	val := src.Context().Value(DecompressorsContextKey)
+	if val == nil {
+		return DefaultDecompressors()
+	}
+	return val.([]Decompressor)
+}
+
+// Set in the context of a source format in which handler must write archive
+// A zip handler can write its entries straight in this format when it implements EntryOutputFormat (see Session.Archive)
+func SetCtxOutputFormat(src *Source, format OutputFormat) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, OutputFormatContextKey, format))
+	*src = *ctxValueReq
+}
+
+// Retrieve format in which handler must write archive, zip format is given when not set
+// This could be use for a zip handler
+func CtxOutputFormat(src *Source) OutputFormat {
+	val := src.Context().Value(OutputFormatContextKey)
+	if val == nil {
+		return ZipFormat{}
+	}
+	return val.(OutputFormat)
+}
+
+// Set in the context of a source if credentials from default providers (environment, netrc file and git credential helpers)
+// can be sent to plain http urls, they are only sent to https urls otherwise
+// This could be use for a zip handler
+func SetCtxPlainHttpCredentials(src *Source, allow bool) {
+	parentContext := src.Context()
+	ctxValueReq := src.WithContext(context.WithValue(parentContext, PlainHttpCredentialsContextKey, allow))
+	*src = *ctxValueReq
+}
+
+// Retrieve if credentials from default providers can be sent to plain http urls from context
+// This could be use for a zip handler
+func CtxPlainHttpCredentials(src *Source) bool {
+	val := src.Context().Value(PlainHttpCredentialsContextKey)
+	if val == nil {
+		return false
+	}
+	return val.(bool)
+}
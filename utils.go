@@ -2,6 +2,7 @@ package zipper
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"debug/elf"
 	"debug/macho"
@@ -21,7 +22,23 @@ func IsWebURL(path string) bool {
 	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
 }
 
+// reader which stop reading when context is done
+type ctxReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+// Create a reader which return context error when reading after context is done
+func NewContextReader(ctx context.Context, reader io.Reader) io.Reader {
+	return &ctxReader{ctx, reader}
+}
 
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
 
 // Create sha1 from a reader by loading in maximum 5kb
 func GetSha1FromReader(reader io.Reader) (string, error) {
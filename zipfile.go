@@ -2,8 +2,13 @@ package zipper
 
 import (
 	"io"
+	"io/ioutil"
+	"os"
 )
 
+// Size returned by a ZipReadCloser when zip is streamed and its size can't be known up front
+const UnknownSize int64 = -1
+
 type ZipReadCloser interface {
 	io.ReadCloser
 	Size() int64
@@ -13,11 +18,114 @@ type ZipFile struct {
 	file  io.ReadCloser
 	size  int64
 	clean func() error
+	// name of output format in which archive has been written, empty for a zip
+	format string
 }
 
 // Create a new ZipFile
 func NewZipFile(file io.ReadCloser, size int64, clean func() error) *ZipFile {
-	return &ZipFile{file, size, clean}
+	return &ZipFile{file: file, size: size, clean: clean}
+}
+
+// Create a new ZipFile which is produced by write function through a pipe while consumer reads it.
+// Size of this zip file is UnknownSize.
+// clean function is called on close after write function has ended
+func NewStreamZipFile(write func(w io.Writer) error, clean func() error) *ZipFile {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(write(pw))
+	}()
+	return NewZipFile(pr, UnknownSize, func() error {
+		<-done
+		if clean == nil {
+			return nil
+		}
+		return clean()
+	})
+}
+
+// Create a new ZipFile by writing entirely content from write function in a temp file
+// clean function is called on close after temp file has been removed
+func NewTempZipFile(prefix string, write func(w io.Writer) error, clean func() error) (*ZipFile, error) {
+	if clean == nil {
+		clean = func() error {
+			return nil
+		}
+	}
+	zipFile, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		clean()
+		return nil, err
+	}
+	removeFunc := func() error {
+		err := os.Remove(zipFile.Name())
+		if err != nil {
+			return err
+		}
+		return clean()
+	}
+	err = write(zipFile)
+	zipFile.Close()
+	if err != nil {
+		removeFunc()
+		return nil, err
+	}
+	file, err := os.Open(zipFile.Name())
+	if err != nil {
+		removeFunc()
+		return nil, err
+	}
+	fs, err := file.Stat()
+	if err != nil {
+		file.Close()
+		removeFunc()
+		return nil, err
+	}
+	return NewZipFile(file, fs.Size(), removeFunc), nil
+}
+
+// create a zip file streamed or buffered in a temp file depending on streaming choice set on source
+func makeZipFile(src *Source, prefix string, write func(w io.Writer) error, clean func() error) (*ZipFile, error) {
+	if CtxStreaming(src) {
+		return NewStreamZipFile(write, clean), nil
+	}
+	return NewTempZipFile(prefix, write, clean)
+}
+
+// create an archive in output format set on source (see CtxOutputFormat), written entry by entry while walking files,
+// a zip is created when output format can't be written entry by entry
+func makeArchiveFile(src *Source, prefix string, write func(w EntryWriter) error, clean func() error) (*ZipFile, error) {
+	format, ok := CtxOutputFormat(src).(EntryOutputFormat)
+	if !ok {
+		format = ZipFormat{}
+	}
+	zipFile, err := makeZipFile(src, prefix, func(w io.Writer) error {
+		entryWriter, err := format.NewEntryWriter(w)
+		if err != nil {
+			return err
+		}
+		defer entryWriter.Close()
+		err = write(entryWriter)
+		if err != nil {
+			return err
+		}
+		return entryWriter.Close()
+	}, clean)
+	if err != nil {
+		return nil, err
+	}
+	zipFile.format = format.Name()
+	return zipFile, nil
+}
+
+// name of output format in which archive has been written by handler
+func archiveFormat(zipFile ZipReadCloser) string {
+	if f, ok := zipFile.(*ZipFile); ok && f.format != "" {
+		return f.format
+	}
+	return FormatZip
 }
 
 func (f ZipFile) Read(p []byte) (int, error) {
@@ -33,6 +141,7 @@ func (f ZipFile) Close() error {
 }
 
 // Retrieve size of the zip file
+// It returns UnknownSize when zip is streamed
 func (f ZipFile) Size() int64 {
 	return f.size
 }
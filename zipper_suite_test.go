@@ -55,6 +55,21 @@ func TestZipper(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Zipper Suite")
 }
+
+// netrc file and git config of user must not give credentials to tests
+var _ = BeforeSuite(func() {
+	home, err := ioutil.TempDir("", "zipper-home")
+	Expect(err).NotTo(HaveOccurred())
+	os.Setenv("HOME", home)
+	os.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	os.Unsetenv("GIT_CONFIG_GLOBAL")
+	os.Unsetenv("NETRC")
+})
+
+var _ = AfterSuite(func() {
+	os.RemoveAll(os.Getenv("HOME"))
+})
+
 func readFileInZip(index int, reader *zip.Reader) (string, string) {
 	buf := &bytes.Buffer{}
 	file := reader.File[index]